@@ -36,16 +36,25 @@ var (
 	// Revision is the git commit id (added at compile time via -X main.Revision=$REVISION)
 	Revision string
 
-	org          = flag.String("org", "", "Organization name for Chainguard images")
-	registry     = flag.String("registry", "", "Custom registry for Chainguard images")
-	update       = flag.Bool("update", false, "Update mappings before conversion")
-	mappingsFile = flag.String("mappings", "", "Path to custom mappings file")
-	noBuiltIn    = flag.Bool("no-builtin", false, "Don't use built-in mappings")
-	inPlace      = flag.Bool("in-place", false, "Convert Dockerfile in place")
-	jsonOutput   = flag.Bool("json", false, "Output in JSON format")
-	apkoOutput   = flag.String("apko", "", "Output path for apko overlay configuration")
-	directApko   = flag.String("direct-apko", "", "Convert Dockerfile directly to apko overlay and save to the specified path")
-	debugMode    = flag.Bool("debug", false, "Enable debug logging")
+	org               = flag.String("org", "", "Organization name for Chainguard images")
+	registry          = flag.String("registry", "", "Custom registry for Chainguard images")
+	update            = flag.Bool("update", false, "Update mappings before conversion")
+	mappingsFile      = flag.String("mappings", "", "Path to custom mappings file")
+	noBuiltIn         = flag.Bool("no-builtin", false, "Don't use built-in mappings")
+	inPlace           = flag.Bool("in-place", false, "Convert Dockerfile in place")
+	jsonOutput        = flag.Bool("json", false, "Output in JSON format")
+	apkoOutput        = flag.String("apko", "", "Output path for apko overlay configuration")
+	directApko        = flag.String("direct-apko", "", "Convert Dockerfile directly to apko overlay and save to the specified path")
+	debugMode         = flag.Bool("debug", false, "Enable debug logging")
+	restoreUser       = flag.String("restore-user", "", "Switch back from USER root after converted RUN lines: none, final or all stages")
+	target            = flag.String("target", "", "Only convert the given stage and the stages it depends on")
+	splitRuntime      = flag.Bool("split-runtime", false, "Split a single-stage Dockerfile into a -dev build stage and a minimal runtime stage")
+	convertScratch    = flag.Bool("convert-scratch", false, "Convert scratch stages that copy in dynamically linked binaries to glibc-dynamic or cc-dynamic")
+	nonRoot           = flag.Bool("nonroot", false, "Use the nonroot user (65532) of Chainguard images instead of creating app users")
+	preferApkForTools = flag.Bool("prefer-apk-for-tools", false, "Install the tools packaged in Wolfi with apk instead of pip, npm -g, gem, cargo or go install")
+	tagStrategy       = flag.String("tag-strategy", "", "How much of the original version to keep in tags: latest, major, minor (default) or preserve")
+	tagCatalog        = flag.String("tag-catalog", "", "Path to a catalog of available tags (YAML/JSON file or OCI layout) to check converted tags against")
+	pin               = flag.String("pin", "", "Pin converted images to digests from an OCI layout directory or a registry endpoint (e.g. https://cgr.dev)")
 )
 
 func main() {
@@ -54,12 +63,15 @@ func main() {
 	// Parse command line arguments
 	flag.Parse()
 
-	// Set debug mode in apko package
+	// Set debug mode in apko package, and log debug messages
 	apko.Debug = *debugMode
+	if *debugMode {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
 
 	// Handle update flag
 	if *update {
-		if err := dfc.Update(ctx, dfc.UpdateOptions{}); err != nil {
+		if err := dfc.Update(ctx, dfc.UpdateOptions{UserAgent: userAgent()}); err != nil {
 			log.Fatalf("Failed to update mappings: %v", err)
 		}
 		// If only updating mappings, exit
@@ -94,9 +106,16 @@ func main() {
 
 	// Convert to Chainguard format
 	opts := dfc.Options{
-		Organization: *org,
-		Registry:     *registry,
-		Update:       *update,
+		Organization:      *org,
+		Registry:          *registry,
+		Update:            *update,
+		RestoreUser:       dfc.UserPolicy(*restoreUser),
+		Target:            *target,
+		SplitRuntime:      *splitRuntime,
+		ConvertScratch:    *convertScratch,
+		NonRoot:           *nonRoot,
+		PreferApkForTools: *preferApkForTools,
+		TagStrategy:       dfc.TagStrategy(*tagStrategy),
 	}
 	if *mappingsFile != "" {
 		mappingsData, err := os.ReadFile(*mappingsFile)
@@ -112,6 +131,20 @@ func main() {
 	if *noBuiltIn {
 		opts.NoBuiltIn = true
 	}
+	if *tagCatalog != "" {
+		catalog, err := dfc.LoadTagCatalog(*tagCatalog)
+		if err != nil {
+			log.Fatalf("Failed to load tag catalog: %v", err)
+		}
+		opts.TagCatalog = catalog
+	}
+	if *pin != "" {
+		resolver, err := dfc.NewDigestResolver(*pin, userAgent())
+		if err != nil {
+			log.Fatalf("Failed to set up digest pinning: %v", err)
+		}
+		opts.DigestResolver = resolver
+	}
 
 	converted, err := dockerfile.Convert(ctx, opts)
 	if err != nil {
@@ -274,6 +307,14 @@ func main() {
 	}
 }
 
+// userAgent returns the user agent of network requests, empty for the default when the version is unknown
+func userAgent() string {
+	if Version == "" {
+		return ""
+	}
+	return "dfc/" + Version
+}
+
 func mainE(ctx context.Context) error {
 	ctx, done := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer done()
@@ -291,6 +332,15 @@ func cli() *cobra.Command {
 	var apkoOutput string
 	var directApko string
 	var debug bool
+	var restoreUser string
+	var target string
+	var splitRuntime bool
+	var convertScratch bool
+	var nonRoot bool
+	var preferApkForTools bool
+	var tagStrategy string
+	var tagCatalog string
+	var pin string
 
 	// Default log level is info
 	var level = slag.Level(slog.LevelInfo)
@@ -323,9 +373,7 @@ func cli() *cobra.Command {
 				updateOpts := dfc.UpdateOptions{}
 
 				// Set UserAgent if version info is available
-				if Version != "" {
-					updateOpts.UserAgent = "dfc/" + Version
-				}
+				updateOpts.UserAgent = userAgent()
 
 				if err := dfc.Update(ctx, updateOpts); err != nil {
 					return fmt.Errorf("failed to update: %w", err)
@@ -365,10 +413,17 @@ func cli() *cobra.Command {
 
 			// Setup conversion options
 			opts := dfc.Options{
-				Organization: org,
-				Registry:     registry,
-				Update:       updateFlag,
-				NoBuiltIn:    noBuiltInFlag,
+				Organization:      org,
+				Registry:          registry,
+				Update:            updateFlag,
+				NoBuiltIn:         noBuiltInFlag,
+				RestoreUser:       dfc.UserPolicy(restoreUser),
+				Target:            target,
+				SplitRuntime:      splitRuntime,
+				ConvertScratch:    convertScratch,
+				NonRoot:           nonRoot,
+				PreferApkForTools: preferApkForTools,
+				TagStrategy:       dfc.TagStrategy(tagStrategy),
 			}
 
 			// If custom mappings file is provided, load it as ExtraMappings
@@ -387,6 +442,25 @@ func cli() *cobra.Command {
 				opts.ExtraMappings = extraMappings
 			}
 
+			// If a tag catalog is provided, check converted tags against it
+			if tagCatalog != "" {
+				log.Info("Loading tag catalog", "path", tagCatalog)
+				catalog, err := dfc.LoadTagCatalog(tagCatalog)
+				if err != nil {
+					return err
+				}
+				opts.TagCatalog = catalog
+			}
+
+			// If a digest source is provided, pin converted images to digests
+			if pin != "" {
+				resolver, err := dfc.NewDigestResolver(pin, userAgent())
+				if err != nil {
+					return err
+				}
+				opts.DigestResolver = resolver
+			}
+
 			// If --no-builtin flag is used without --mappings, warn the user
 			if noBuiltInFlag && mappingsFile == "" {
 				log.Warn("Using --no-builtin without --mappings will use default conversion logic without any package/image mappings")
@@ -602,6 +676,15 @@ func cli() *cobra.Command {
 	cmd.Flags().StringVar(&apkoOutput, "apko", "", "output path for apko configuration file")
 	cmd.Flags().StringVar(&directApko, "direct-apko", "", "convert Dockerfile directly to apko overlay and save to the specified path")
 	cmd.Flags().BoolVar(&debug, "debug", false, "enable debug logging")
+	cmd.Flags().StringVar(&restoreUser, "restore-user", "", "switch back from USER root after converted RUN lines: none, final or all stages")
+	cmd.Flags().StringVar(&target, "target", "", "only convert the given stage and the stages it depends on")
+	cmd.Flags().BoolVar(&splitRuntime, "split-runtime", false, "split a single-stage Dockerfile into a -dev build stage and a minimal runtime stage")
+	cmd.Flags().BoolVar(&convertScratch, "convert-scratch", false, "convert scratch stages that copy in dynamically linked binaries to glibc-dynamic or cc-dynamic")
+	cmd.Flags().BoolVar(&nonRoot, "nonroot", false, "use the nonroot user (65532) of Chainguard images instead of creating app users")
+	cmd.Flags().BoolVar(&preferApkForTools, "prefer-apk-for-tools", false, "install the tools packaged in Wolfi with apk instead of pip, npm -g, gem, cargo or go install")
+	cmd.Flags().StringVar(&tagStrategy, "tag-strategy", "", "how much of the original version to keep in tags: latest, major, minor (default) or preserve")
+	cmd.Flags().StringVar(&tagCatalog, "tag-catalog", "", "path to a catalog of available tags (YAML/JSON file or OCI layout) to check converted tags against")
+	cmd.Flags().StringVar(&pin, "pin", "", "pin converted images to digests from an OCI layout directory or a registry endpoint (e.g. https://cgr.dev)")
 	cmd.Flags().Var(&level, "log-level", "log level (e.g. debug, info, warn, error)")
 
 	return cmd
@@ -275,7 +275,7 @@ func ConvertDockerfileToApko(dockerfile *dfc.Dockerfile) (map[string]*ApkoConfig
 	stageConfigs := make(map[string]*ApkoConfig)
 	var currentConfig *ApkoConfig
 	var currentStageName string
-	stageIndex := 0
+	graph := dockerfile.Stages()
 
 	// Initialize maps for the current stage's context
 	seenPackages := make(map[string]bool)
@@ -286,12 +286,7 @@ func ConvertDockerfileToApko(dockerfile *dfc.Dockerfile) (map[string]*ApkoConfig
 	for _, line := range dockerfile.Lines {
 		if line.From != nil {
 			// Start new stage
-			if line.From.Alias != "" {
-				currentStageName = line.From.Alias
-			} else {
-				currentStageName = fmt.Sprintf("stage%d", stageIndex)
-				stageIndex++
-			}
+			currentStageName = graph.Stage(line.Stage).Name()
 
 			currentConfig = &ApkoConfig{
 				Contents: struct {
@@ -595,6 +590,10 @@ func ConvertDockerfileToApko(dockerfile *dfc.Dockerfile) (map[string]*ApkoConfig
 						}
 						if fromVal != "" {
 							// If --from is used, the 'src' is relative to that stage.
+							// Stage references use the same names as the returned configs.
+							if stage := graph.Lookup(fromVal); stage != nil {
+								fromVal = stage.Name()
+							}
 							pathEntry.Source = fmt.Sprintf("--from=%s %s", fromVal, src)
 						}
 						currentConfig.Paths = append(currentConfig.Paths, pathEntry)
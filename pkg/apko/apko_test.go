@@ -1,99 +1,110 @@
 package apko
 
 import (
+	"context"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
 	"github.com/chainguard-dev/dfc/pkg/dfc"
 )
 
 func TestConvertDockerfileToApko(t *testing.T) {
 	tests := []struct {
 		name       string
-		dockerfile *dfc.Dockerfile
-		want       *ApkoConfig
+		dockerfile string
+		want       map[string]*ApkoConfig
 		wantErr    bool
 	}{
 		{
 			name: "simple dockerfile",
-			dockerfile: &dfc.Dockerfile{
-				Lines: []*dfc.DockerfileLine{
-					{
-						From: &dfc.FromDetails{
-							Base: "cgr.dev/ORG/alpine",
-							Tag:  "latest-dev",
-						},
-					},
-					{
-						Run: &dfc.RunDetails{
-							Packages: []string{"nginx"},
-						},
+			dockerfile: `FROM cgr.dev/ORG/alpine:latest-dev
+RUN apk add --no-cache nginx
+WORKDIR /usr/share/nginx
+ENV PATH=/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin
+USER nginx`,
+			want: map[string]*ApkoConfig{
+				"stage0": {
+					Contents: struct {
+						Repositories []string `yaml:"repositories"`
+						Packages     []string `yaml:"packages"`
+					}{
+						Packages: []string{"alpine-base", "nginx"},
 					},
-					{
-						Raw: "WORKDIR /usr/share/nginx",
+					WorkDir: "/usr/share/nginx",
+					Environment: map[string]string{
+						"PATH": "/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
 					},
-					{
-						Raw: "ENV PATH=/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
-					},
-					{
-						Raw: "USER nginx",
+					Accounts: struct {
+						Users  []User  `yaml:"users,omitempty"`
+						Groups []Group `yaml:"groups,omitempty"`
+						RunAs  string  `yaml:"run-as,omitempty"`
+					}{
+						RunAs: "nginx",
 					},
 				},
 			},
-			want: &ApkoConfig{
-				Contents: struct {
-					Repositories []string `yaml:"repositories"`
-					Packages     []string `yaml:"packages"`
-				}{
-					Repositories: []string{"https://dl-cdn.alpinelinux.org/alpine/edge/main"},
-					Packages:     []string{"alpine-base", "nginx"},
-				},
-				WorkDir: "/usr/share/nginx",
-				Environment: map[string]string{
-					"PATH": "/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+		},
+		{
+			name: "multi-stage dockerfile",
+			dockerfile: `FROM cgr.dev/ORG/go:latest-dev AS builder
+RUN apk add --no-cache make
+
+FROM cgr.dev/ORG/static:latest
+COPY --from=0 /app /app`,
+			want: map[string]*ApkoConfig{
+				"builder": {
+					Contents: struct {
+						Repositories []string `yaml:"repositories"`
+						Packages     []string `yaml:"packages"`
+					}{
+						Packages: []string{"make"},
+					},
 				},
-				Accounts: struct {
-					Users  []User  `yaml:"users,omitempty"`
-					Groups []Group `yaml:"groups,omitempty"`
-					RunAs  string  `yaml:"run-as,omitempty"`
-				}{
-					RunAs: "nginx",
+				"stage1": {
+					Paths: []Path{{Path: "/app", Type: "hardlink", Source: "--from=builder /app"}},
 				},
 			},
-			wantErr: false,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := ConvertDockerfileToApko(tt.dockerfile)
+			dockerfile, err := dfc.ParseDockerfile(context.Background(), []byte(tt.dockerfile))
+			if err != nil {
+				t.Fatalf("ParseDockerfile() error = %v", err)
+			}
+			got, err := ConvertDockerfileToApko(dockerfile)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ConvertDockerfileToApko() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
-			// Compare repositories
-			if len(got.Contents.Repositories) != len(tt.want.Contents.Repositories) {
-				t.Errorf("ConvertDockerfileToApko() repositories = %v, want %v", got.Contents.Repositories, tt.want.Contents.Repositories)
-			}
-
-			// Compare packages
-			if len(got.Contents.Packages) != len(tt.want.Contents.Packages) {
-				t.Errorf("ConvertDockerfileToApko() packages = %v, want %v", got.Contents.Packages, tt.want.Contents.Packages)
-			}
-
-			// Compare workdir
-			if got.WorkDir != tt.want.WorkDir {
-				t.Errorf("ConvertDockerfileToApko() workdir = %v, want %v", got.WorkDir, tt.want.WorkDir)
-			}
-
-			// Compare environment
-			if len(got.Environment) != len(tt.want.Environment) {
-				t.Errorf("ConvertDockerfileToApko() environment = %v, want %v", got.Environment, tt.want.Environment)
+			if len(got) != len(tt.want) {
+				t.Fatalf("ConvertDockerfileToApko() returned %d stages, want %d", len(got), len(tt.want))
 			}
-
-			// Compare run-as user
-			if got.Accounts.RunAs != tt.want.Accounts.RunAs {
-				t.Errorf("ConvertDockerfileToApko() run-as = %v, want %v", got.Accounts.RunAs, tt.want.Accounts.RunAs)
+			for name, want := range tt.want {
+				config, ok := got[name]
+				if !ok {
+					t.Errorf("ConvertDockerfileToApko() missing stage %q", name)
+					continue
+				}
+				if diff := cmp.Diff(want.Contents.Packages, config.Contents.Packages, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("ConvertDockerfileToApko() stage %q packages mismatch (-want +got):\n%s", name, diff)
+				}
+				if config.WorkDir != want.WorkDir {
+					t.Errorf("ConvertDockerfileToApko() stage %q workdir = %v, want %v", name, config.WorkDir, want.WorkDir)
+				}
+				if diff := cmp.Diff(want.Environment, config.Environment, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("ConvertDockerfileToApko() stage %q environment mismatch (-want +got):\n%s", name, diff)
+				}
+				if config.Accounts.RunAs != want.Accounts.RunAs {
+					t.Errorf("ConvertDockerfileToApko() stage %q run-as = %v, want %v", name, config.Accounts.RunAs, want.Accounts.RunAs)
+				}
+				if diff := cmp.Diff(want.Paths, config.Paths, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("ConvertDockerfileToApko() stage %q paths mismatch (-want +got):\n%s", name, diff)
+				}
 			}
 		})
 	}
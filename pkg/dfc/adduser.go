@@ -6,6 +6,7 @@ SPDX-License-Identifier: Apache-2.0
 package dfc
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -26,6 +27,7 @@ func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
 	var resultArgs []string
 	var username string
 	var hasUsername bool
+	var groups []string
 	i := 0
 
 	// Process arguments
@@ -106,6 +108,19 @@ func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
 				i++
 			}
 
+		// Supplementary groups are added with addgroup once the user exists
+		case "-G", "--groups":
+			if i+1 < len(part.Args) {
+				groups = append(groups, splitGroups(part.Args[i+1])...)
+				i += 2
+			} else {
+				i++
+			}
+
+		// Options that are simply removed (adduser does not use lastlog and creates a user group by default)
+		case "-l", "--no-log-init", "-U", "--user-group":
+			i++
+
 		// Options that we skip along with their arguments
 		case "-k", "--skel", "-N", "--no-user-group":
 			if i+1 < len(part.Args) && !strings.HasPrefix(part.Args[i+1], "-") {
@@ -114,6 +129,17 @@ func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
 				i++
 			}
 
+		// Options without an adduser equivalent are left in place, along with their arguments, so the
+		// command fails instead of silently creating a different user (see userAddNeedsShadow)
+		case "-K", "--key":
+			resultArgs = append(resultArgs, arg)
+			if i+1 < len(part.Args) {
+				resultArgs = append(resultArgs, part.Args[i+1])
+				i += 2
+			} else {
+				i++
+			}
+
 		// Include other parts that haven't been processed
 		default:
 			resultArgs = append(resultArgs, arg)
@@ -124,12 +150,39 @@ func ConvertUserAddToAddUser(part *ShellPart) *ShellPart {
 	// Add username at the end
 	if hasUsername {
 		resultArgs = append(resultArgs, username)
+
+		// Follow up with adding the user to its supplementary groups
+		for _, group := range groups {
+			resultArgs = append(resultArgs, "&&", CommandAddGroup, username, group)
+		}
 	}
 
 	result.Args = resultArgs
 	return result
 }
 
+// splitGroups splits a comma separated list of groups
+func splitGroups(groups string) []string {
+	var result []string
+	for _, group := range strings.Split(unquote(groups), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// userAddNeedsShadow checks if a useradd command uses options that adduser has no equivalent for
+func userAddNeedsShadow(part *ShellPart) bool {
+	for _, arg := range part.Args {
+		switch arg {
+		case "-o", "--non-unique", "-K", "--key", "-e", "--expiredate", "-f", "--inactive":
+			return true
+		}
+	}
+	return false
+}
+
 // ConvertGroupAddToAddGroup converts a groupadd command to the equivalent addgroup command
 func ConvertGroupAddToAddGroup(part *ShellPart) *ShellPart {
 	if part.Command != CommandGroupAdd {
@@ -205,3 +258,220 @@ func ConvertGroupAddToAddGroup(part *ShellPart) *ShellPart {
 	result.Args = resultArgs
 	return result
 }
+
+// userModOptions are the usermod options that have a busybox equivalent, and whether they take a value
+var userModOptions = map[string]bool{
+	"-a": false, "--append": false,
+	"-G": true, "--groups": true,
+	"-s": true, "--shell": true,
+	"-d": true, "--home": true,
+	"-c": true, "--comment": true,
+	"-L": false, "--lock": false,
+	"-U": false, "--unlock": false,
+}
+
+// expandShortOptions splits grouped short options such as -aG into -a -G
+func expandShortOptions(args []string) []string {
+	var result []string
+	for _, arg := range args {
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			for _, c := range arg[1:] {
+				result = append(result, "-"+string(c))
+			}
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// userModChanges holds the changes requested by a usermod command
+type userModChanges struct {
+	username string
+	append   bool
+	groups   []string
+	shell    string
+	home     string
+	comment  string
+	lock     bool
+	unlock   bool
+	other    bool // Options without a busybox equivalent
+}
+
+// parseUserMod parses the arguments of a usermod command
+func parseUserMod(args []string) userModChanges {
+	var changes userModChanges
+	args = expandShortOptions(args)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		takesValue, known := userModOptions[arg]
+		if !strings.HasPrefix(arg, "-") {
+			changes.username = arg
+			continue
+		}
+		if !known {
+			changes.other = true
+			continue
+		}
+
+		var value string
+		if takesValue && i+1 < len(args) {
+			value = args[i+1]
+			i++
+		}
+		switch arg {
+		case "-a", "--append":
+			changes.append = true
+		case "-G", "--groups":
+			changes.groups = append(changes.groups, splitGroups(value)...)
+		case "-s", "--shell":
+			changes.shell = value
+		case "-d", "--home":
+			changes.home = value
+		case "-c", "--comment":
+			changes.comment = unquote(value)
+		case "-L", "--lock":
+			changes.lock = true
+		case "-U", "--unlock":
+			changes.unlock = true
+		}
+	}
+	return changes
+}
+
+// userModNeedsShadow checks if a usermod command makes changes that busybox has no equivalent for.
+// Replacing the supplementary groups (-G without -a) would need removing the user from other groups.
+func userModNeedsShadow(part *ShellPart) bool {
+	changes := parseUserMod(part.Args)
+	return changes.other || changes.username == "" || (len(changes.groups) > 0 && !changes.append)
+}
+
+// passwdFieldUpdate returns a sed command updating a field of a user in /etc/passwd.
+// The fields are name:password:UID:GID:GECOS:home:shell, field is 1-based.
+func passwdFieldUpdate(username string, field int, value string) []string {
+	return []string{CommandSed, "-i", "-E",
+		fmt.Sprintf(`"s#^(%s(:[^:]*){%d}):[^:]*#\1:%s#"`, sedQuoteMeta(username), field-2, sedQuoteReplacement(value)), "/etc/passwd"}
+}
+
+// sedQuoteReplacement escapes a string used literally as the replacement of a sed expression in
+// double quotes: the characters special to sed (\, & and the # delimiter) and to the shell
+func sedQuoteReplacement(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			// The shell turns \\ into \ in double quotes, sed needs \\ for a literal backslash
+			b.WriteString(`\\\\`)
+		case '&', '#', '$', '"', '`':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sedQuoteMeta escapes the extended regular expression metacharacters of a string matched
+// literally by a sed expression in double quotes, like regexp.QuoteMeta does for Go expressions
+func sedQuoteMeta(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '$':
+			// The shell turns \$ into $ in double quotes, keep the backslash for sed
+			b.WriteString(`\\\$`)
+		case strings.ContainsRune(`.+*?()|[]{}^#`, r):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ConvertUserModToBusybox converts a usermod command to the equivalent busybox commands:
+// supplementary groups are added with addgroup, the shell, home directory and comment are updated
+// in /etc/passwd and accounts are locked or unlocked with passwd
+func ConvertUserModToBusybox(part *ShellPart) *ShellPart {
+	if part.Command != CommandUserMod {
+		return part
+	}
+
+	changes := parseUserMod(part.Args)
+	if changes.username == "" {
+		return part
+	}
+
+	var commands [][]string
+	for _, group := range changes.groups {
+		commands = append(commands, []string{CommandAddGroup, changes.username, group})
+	}
+	if changes.comment != "" {
+		commands = append(commands, passwdFieldUpdate(changes.username, 5, changes.comment))
+	}
+	if changes.home != "" {
+		commands = append(commands, passwdFieldUpdate(changes.username, 6, changes.home))
+	}
+	if changes.shell != "" {
+		commands = append(commands, passwdFieldUpdate(changes.username, 7, changes.shell))
+	}
+	if changes.lock {
+		commands = append(commands, []string{CommandPasswd, "-l", changes.username})
+	}
+	if changes.unlock {
+		commands = append(commands, []string{CommandPasswd, "-u", changes.username})
+	}
+	if len(commands) == 0 {
+		return part
+	}
+
+	return chainCommands(commands, part.ExtraPre, part.Delimiter)
+}
+
+// ConvertGPasswdToBusybox converts gpasswd commands adding or removing a group member
+// to the busybox addgroup and delgroup commands
+func ConvertGPasswdToBusybox(part *ShellPart) *ShellPart {
+	if part.Command != CommandGPasswd || len(part.Args) != 3 {
+		return part
+	}
+
+	var command string
+	switch part.Args[0] {
+	case "-a", "--add":
+		command = CommandAddGroup
+	case "-d", "--delete":
+		command = CommandDelGroup
+	default:
+		return part
+	}
+
+	return &ShellPart{
+		ExtraPre:  part.ExtraPre,
+		Command:   command,
+		Args:      []string{part.Args[1], part.Args[2]},
+		Delimiter: part.Delimiter,
+	}
+}
+
+// gpasswdNeedsShadow checks if a gpasswd command does more than adding or removing a group member
+func gpasswdNeedsShadow(part *ShellPart) bool {
+	converted := ConvertGPasswdToBusybox(part)
+	return converted.Command == CommandGPasswd
+}
+
+// chainCommands joins commands with && into a single shell part
+func chainCommands(commands [][]string, extraPre string, delimiter string) *ShellPart {
+	result := &ShellPart{
+		ExtraPre:  extraPre,
+		Command:   commands[0][0],
+		Args:      commands[0][1:],
+		Delimiter: delimiter,
+	}
+	for _, command := range commands[1:] {
+		result.Args = append(result.Args, "&&")
+		result.Args = append(result.Args, command...)
+	}
+	return result
+}
@@ -6,7 +6,10 @@ SPDX-License-Identifier: Apache-2.0
 package dfc
 
 import (
+	"context"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestConvertUserAddToAddUser(t *testing.T) {
@@ -202,6 +205,17 @@ func TestConvertUserAddToAddUser(t *testing.T) {
 				Args:    []string{"--uid", "1001", "myuser"},
 			},
 		},
+		{
+			name: "non-unique user ID and login.defs keys kept",
+			input: &ShellPart{
+				Command: CommandUserAdd,
+				Args:    []string{"-o", "-u", "0", "-K", "UID_MIN=100", "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandAddUser,
+				Args:    []string{"-o", "--uid", "0", "-K", "UID_MIN=100", "myuser"},
+			},
+		},
 		{
 			name: "no home directory",
 			input: &ShellPart{
@@ -235,6 +249,39 @@ func TestConvertUserAddToAddUser(t *testing.T) {
 				Args:    []string{"--shell", "/bin/bash", "--uid", "1001", "--ingroup", "mygroup", "myuser"},
 			},
 		},
+		{
+			name: "supplementary groups",
+			input: &ShellPart{
+				Command: CommandUserAdd,
+				Args:    []string{"-m", "-G", "wheel,docker", "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandAddUser,
+				Args:    []string{"myuser", "&&", CommandAddGroup, "myuser", "wheel", "&&", CommandAddGroup, "myuser", "docker"},
+			},
+		},
+		{
+			name: "supplementary groups long option",
+			input: &ShellPart{
+				Command: CommandUserAdd,
+				Args:    []string{"--groups", "sudo", "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandAddUser,
+				Args:    []string{"myuser", "&&", CommandAddGroup, "myuser", "sudo"},
+			},
+		},
+		{
+			name: "no log init and user group",
+			input: &ShellPart{
+				Command: CommandUserAdd,
+				Args:    []string{"-l", "-U", "-u", "1001", "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandAddUser,
+				Args:    []string{"--uid", "1001", "myuser"},
+			},
+		},
 		{
 			name: "preserves extra parts",
 			input: &ShellPart{
@@ -468,3 +515,245 @@ func TestConvertGroupAddToAddGroup(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertUserModToBusybox(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       *ShellPart
+		expected    *ShellPart
+		needsShadow bool
+	}{
+		{
+			name: "append groups",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-aG", "docker,wheel", "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandAddGroup,
+				Args:    []string{"myuser", "docker", "&&", CommandAddGroup, "myuser", "wheel"},
+			},
+		},
+		{
+			name: "append groups long options",
+			input: &ShellPart{
+				Command:   CommandUserMod,
+				Args:      []string{"--append", "--groups", "docker", "myuser"},
+				Delimiter: "&&",
+			},
+			expected: &ShellPart{
+				Command:   CommandAddGroup,
+				Args:      []string{"myuser", "docker"},
+				Delimiter: "&&",
+			},
+		},
+		{
+			name: "shell and home",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-s", "/bin/zsh", "-d", "/app", "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandSed,
+				Args: []string{"-i", "-E", `"s#^(myuser(:[^:]*){4}):[^:]*#\1:/app#"`, "/etc/passwd",
+					"&&", CommandSed, "-i", "-E", `"s#^(myuser(:[^:]*){5}):[^:]*#\1:/bin/zsh#"`, "/etc/passwd"},
+			},
+		},
+		{
+			name: "username with regex metacharacters",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-s", "/bin/sh", "app.user$"},
+			},
+			expected: &ShellPart{
+				Command: CommandSed,
+				Args:    []string{"-i", "-E", `"s#^(app\.user\\\$(:[^:]*){5}):[^:]*#\1:/bin/sh#"`, "/etc/passwd"},
+			},
+		},
+		{
+			name: "comment",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-c", `"App User"`, "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandSed,
+				Args:    []string{"-i", "-E", `"s#^(myuser(:[^:]*){3}):[^:]*#\1:App User#"`, "/etc/passwd"},
+			},
+		},
+		{
+			name: "comment with sed and shell characters",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-c", `"R&D #1 $HOME"`, "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandSed,
+				Args:    []string{"-i", "-E", `"s#^(myuser(:[^:]*){3}):[^:]*#\1:R\&D \#1 \$HOME#"`, "/etc/passwd"},
+			},
+		},
+		{
+			name: "lock",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-L", "myuser"},
+			},
+			expected: &ShellPart{
+				Command: CommandPasswd,
+				Args:    []string{"-l", "myuser"},
+			},
+		},
+		{
+			name: "replace groups",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-G", "docker", "myuser"},
+			},
+			needsShadow: true,
+		},
+		{
+			name: "change UID",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-u", "1001", "myuser"},
+			},
+			needsShadow: true,
+		},
+		{
+			name: "move home",
+			input: &ShellPart{
+				Command: CommandUserMod,
+				Args:    []string{"-m", "-d", "/app", "myuser"},
+			},
+			needsShadow: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := userModNeedsShadow(tc.input); got != tc.needsShadow {
+				t.Fatalf("userModNeedsShadow() = %v, want %v", got, tc.needsShadow)
+			}
+			if tc.needsShadow {
+				return
+			}
+			if diff := cmp.Diff(tc.expected, ConvertUserModToBusybox(tc.input)); diff != "" {
+				t.Errorf("ConvertUserModToBusybox() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConvertGPasswdToBusybox(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       *ShellPart
+		expected    *ShellPart
+		needsShadow bool
+	}{
+		{
+			name: "add member",
+			input: &ShellPart{
+				Command: CommandGPasswd,
+				Args:    []string{"-a", "myuser", "docker"},
+			},
+			expected: &ShellPart{
+				Command: CommandAddGroup,
+				Args:    []string{"myuser", "docker"},
+			},
+		},
+		{
+			name: "delete member",
+			input: &ShellPart{
+				Command: CommandGPasswd,
+				Args:    []string{"--delete", "myuser", "docker"},
+			},
+			expected: &ShellPart{
+				Command: CommandDelGroup,
+				Args:    []string{"myuser", "docker"},
+			},
+		},
+		{
+			name: "set members",
+			input: &ShellPart{
+				Command: CommandGPasswd,
+				Args:    []string{"-M", "a,b", "docker"},
+			},
+			needsShadow: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := gpasswdNeedsShadow(tc.input); got != tc.needsShadow {
+				t.Fatalf("gpasswdNeedsShadow() = %v, want %v", got, tc.needsShadow)
+			}
+			if tc.needsShadow {
+				return
+			}
+			if diff := cmp.Diff(tc.expected, ConvertGPasswdToBusybox(tc.input)); diff != "" {
+				t.Errorf("ConvertGPasswdToBusybox() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUserManagementConversion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name: "supplementary groups",
+			raw: `FROM debian:12
+RUN groupadd docker && useradd -m -l -G wheel,docker app`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN addgroup docker && \
+    adduser app && \
+    addgroup app wheel && \
+    addgroup app docker
+`,
+		},
+		{
+			name: "usermod and chpasswd",
+			raw: `FROM debian:12
+RUN usermod -aG docker app && usermod -s /bin/sh app && echo 'app:x' | chpasswd --md5`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN addgroup app docker && \
+    sed -i -E "s#^(app(:[^:]*){5}):[^:]*#\1:/bin/sh#" /etc/passwd && \
+    echo 'app:x' | chpasswd -m
+`,
+		},
+		{
+			name: "options without busybox equivalent install shadow",
+			raw: `FROM debian:12
+RUN useradd -o -u 0 admin && usermod -u 1001 app`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache shadow && \
+    useradd -o -u 0 admin && \
+    usermod -u 1001 app
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
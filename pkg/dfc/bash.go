@@ -0,0 +1,192 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Bash related constants
+const (
+	PackageBash        = "bash"
+	BashShellDirective = DirectiveShell + ` ["/bin/bash", "-c"]`
+)
+
+// bashism describes shell syntax that bash supports but busybox sh does not
+type bashism struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// bashisms is the list of bash-only syntax detected in RUN lines
+var bashisms = []bashism{
+	{Name: "[[ ]]", Pattern: regexp.MustCompile(`(^|[\s;&|(!])\[\[\s`)},
+	{Name: "source", Pattern: regexp.MustCompile(`(^|[;&|(]\s*)source\s`)},
+	{Name: "arrays", Pattern: regexp.MustCompile(`(^|[\s;&|])(declare|typeset|local)\s+-[a-zA-Z]*[aA]\b|(^|[\s;&|])[A-Za-z_][A-Za-z0-9_]*=\(|\$\{#?[A-Za-z_][A-Za-z0-9_]*\[`)},
+	{Name: "set -o pipefail", Pattern: regexp.MustCompile(`(^|[\s;&|])set\s[^;&|]*-[a-zA-Z]*o\s+pipefail`)},
+	{Name: "here-string", Pattern: regexp.MustCompile(`<<<`)},
+	{Name: "process substitution", Pattern: regexp.MustCompile(`(^|\s)[<>]\(`)},
+	{Name: "function keyword", Pattern: regexp.MustCompile(`(^|[;&|]\s*)function\s+[A-Za-z_]`)},
+}
+
+// singleQuotedRegex matches single quoted strings, which are never interpreted by the shell
+var singleQuotedRegex = regexp.MustCompile(`'[^']*'`)
+
+// DetectBashisms returns the names of the bash-only constructs used in a shell command
+func DetectBashisms(cmd string) []string {
+	cleaned := singleQuotedRegex.ReplaceAllString(removeComments(cmd), "''")
+
+	var found []string
+	for _, b := range bashisms {
+		if b.Pattern.MatchString(cleaned) {
+			found = append(found, b.Name)
+		}
+	}
+	return found
+}
+
+// runCommandText extracts the command portion of a RUN instruction
+func runCommandText(raw string) string {
+	trimmed := strings.TrimSpace(raw)
+	if len(trimmed) < len(DirectiveRun) {
+		return ""
+	}
+	return strings.TrimSpace(trimmed[len(DirectiveRun):])
+}
+
+// isExecForm checks if an instruction argument uses the JSON exec form
+func isExecForm(cmd string) bool {
+	var args []string
+	return json.Unmarshal([]byte(cmd), &args) == nil
+}
+
+// execFormRunsBash checks if an exec form instruction argument runs bash as its executable
+func execFormRunsBash(cmd string) bool {
+	var args []string
+	if json.Unmarshal([]byte(cmd), &args) != nil || len(args) == 0 {
+		return false
+	}
+	return path.Base(args[0]) == PackageBash
+}
+
+// isShellDirective checks if the line is a SHELL directive
+func isShellDirective(raw string) bool {
+	upper := strings.ToUpper(strings.TrimSpace(raw))
+	return strings.HasPrefix(upper, DirectiveShell+" ") || strings.HasPrefix(upper, DirectiveShell+"[")
+}
+
+// installsBash checks if a RUN line installs bash with apk
+func installsBash(line *DockerfileLine) bool {
+	if line.Run == nil || line.Run.Shell == nil {
+		return false
+	}
+	shell := line.Run.Shell.After
+	if shell == nil {
+		shell = line.Run.Shell.Before
+	}
+	if shell == nil {
+		return false
+	}
+	for _, part := range shell.Parts {
+		if part.Command != string(ManagerApk) || !slices.Contains(part.Args, SubcommandAdd) {
+			continue
+		}
+		for _, arg := range part.Args {
+			if name, _, _ := parseApkVersion(arg); name == PackageBash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addBashSupport makes sure that stages relying on bash get it. Converted stages run RUN lines
+// under busybox sh, so when bash-only syntax is detected a SHELL directive switching to bash is
+// inserted before the first such line, and bash is installed right after the FROM line of the
+// stage (or of the stage it builds on). It returns the stages that had bash installed.
+func addBashSupport(lines []*DockerfileLine, graph *StageGraph) map[int]bool {
+	fromLines := make(map[int]*DockerfileLine)
+	bashShell := make(map[int]bool)
+	bashInstalled := make(map[int]bool)
+	needsInstall := make(map[int]bool)
+
+	for _, line := range lines {
+		if line.From != nil {
+			fromLines[line.Stage] = line
+			// Stages built on top of another stage inherit its shell and packages
+			if parent := graph.Stage(line.Stage).Parent; parent > 0 {
+				bashShell[line.Stage] = bashShell[parent]
+				bashInstalled[line.Stage] = bashInstalled[parent]
+			}
+			continue
+		}
+
+		// Only built stages based on a converted image are affected
+		root := graph.Root(line.Stage)
+		if fromLines[root] == nil || fromLines[root].Converted == "" || !graph.Builds(line.Stage) {
+			continue
+		}
+
+		if isShellDirective(line.Raw) {
+			_, args := cutInstruction(line.Raw)
+			bashShell[line.Stage] = execFormRunsBash(args)
+			if bashShell[line.Stage] && !bashInstalled[line.Stage] {
+				needsInstall[root] = true
+				line.Notes = append(line.Notes, "SHELL uses bash, which is not included by default")
+			}
+			continue
+		}
+
+		if line.Run == nil {
+			continue
+		}
+		if installsBash(line) {
+			bashInstalled[line.Stage] = true
+		}
+
+		cmd := runCommandText(line.Raw)
+		if isExecForm(cmd) {
+			// Exec form does not use the default shell but may call bash directly
+			if execFormRunsBash(cmd) && !bashInstalled[line.Stage] {
+				needsInstall[root] = true
+				line.Notes = append(line.Notes, "exec form RUN calls bash, which is not included by default")
+			}
+			continue
+		}
+
+		found := DetectBashisms(cmd)
+		if len(found) == 0 {
+			continue
+		}
+		note := fmt.Sprintf("bash-only syntax detected (%s)", strings.Join(found, ", "))
+		if !bashInstalled[line.Stage] {
+			needsInstall[root] = true
+		}
+		if !bashShell[line.Stage] {
+			converted := line.Converted
+			if converted == "" {
+				converted = line.Raw
+			}
+			line.Converted = BashShellDirective + "\n" + converted
+			bashShell[line.Stage] = true
+			note += ", inserted " + BashShellDirective
+		}
+		line.Notes = append(line.Notes, note)
+	}
+
+	for stage := range needsInstall {
+		fromLine := fromLines[stage]
+		fromLine.Converted += "\n" + DirectiveRun + " " + string(ManagerApk) + " " + SubcommandAdd + " " + ApkNoCacheFlag + " " + PackageBash
+		fromLine.Notes = append(fromLine.Notes, "installed bash, required by later lines of this stage")
+	}
+
+	return needsInstall
+}
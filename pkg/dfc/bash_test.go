@@ -0,0 +1,219 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDetectBashisms(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cmd      string
+		expected []string
+	}{
+		{
+			name:     "posix shell",
+			cmd:      `. /etc/os-release && [ -n "$ID" ] && echo $((1 + 2))`,
+			expected: nil,
+		},
+		{
+			name:     "double brackets",
+			cmd:      `if [[ -f /etc/debian_version ]]; then echo debian; fi`,
+			expected: []string{"[[ ]]"},
+		},
+		{
+			name:     "source",
+			cmd:      `apt-get update && source /opt/venv/bin/activate`,
+			expected: []string{"source"},
+		},
+		{
+			name:     "array assignment",
+			cmd:      `PKGS=(curl git) && echo ${PKGS[@]}`,
+			expected: []string{"arrays"},
+		},
+		{
+			name:     "declare array",
+			cmd:      `declare -a pkgs`,
+			expected: []string{"arrays"},
+		},
+		{
+			name:     "pipefail",
+			cmd:      `set -euo pipefail; curl -fsSL https://example.com | tar -xz`,
+			expected: []string{"set -o pipefail"},
+		},
+		{
+			name:     "pipefail separate flag",
+			cmd:      `set -eux -o pipefail && true`,
+			expected: []string{"set -o pipefail"},
+		},
+		{
+			name:     "here-string and process substitution",
+			cmd:      `read -r a <<< "x" && diff <(echo a) <(echo b)`,
+			expected: []string{"here-string", "process substitution"},
+		},
+		{
+			name:     "function keyword",
+			cmd:      `function setup { echo hi; }; setup`,
+			expected: []string{"function keyword"},
+		},
+		{
+			name:     "single quoted text is ignored",
+			cmd:      `echo 'source [[ x ]]'`,
+			expected: nil,
+		},
+		{
+			name:     "comments are ignored",
+			cmd:      "echo hi # [[ x ]]",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := DetectBashisms(tc.cmd)
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("DetectBashisms() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBashSupport(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name: "bash syntax in unconverted RUN",
+			raw: `FROM python:3.12
+RUN source /opt/venv/bin/activate && pip install flask`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache bash
+SHELL ["/bin/bash", "-c"]
+RUN source /opt/venv/bin/activate && pip install flask
+`,
+		},
+		{
+			name: "shell is only inserted once per stage",
+			raw: `FROM debian:12
+RUN apt-get update && apt-get install -y curl
+RUN [[ -f /etc/os-release ]] && echo found
+RUN set -o pipefail && curl -fsSL https://example.com | sh`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache bash
+RUN apk add --no-cache curl
+SHELL ["/bin/bash", "-c"]
+RUN [[ -f /etc/os-release ]] && echo found
+RUN set -o pipefail && \
+    echo "TODO: replace the installer script https://example.com with apk packages" >&2 && \
+    exit 1
+`,
+		},
+		{
+			name: "existing bash SHELL directive",
+			raw: `FROM node:20
+SHELL ["/bin/bash", "-o", "pipefail", "-c"]
+RUN [[ -d /app ]] || mkdir /app`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+RUN apk add --no-cache bash
+SHELL ["/bin/bash", "-o", "pipefail", "-c"]
+RUN [[ -d /app ]] || mkdir /app`,
+		},
+		{
+			name: "bash already installed",
+			raw: `FROM debian:12
+RUN apt-get update && apt-get install -y bash
+RUN source /etc/os-release`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache bash
+SHELL ["/bin/bash", "-c"]
+RUN source /etc/os-release
+`,
+		},
+		{
+			name: "child stage installs in parent",
+			raw: `FROM node:20 AS base
+RUN echo hello
+
+FROM base AS test
+RUN source ./env.sh && npm test`,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS base
+USER root
+RUN apk add --no-cache bash
+RUN echo hello
+
+FROM base AS test
+SHELL ["/bin/bash", "-c"]
+RUN source ./env.sh && npm test
+`,
+		},
+		{
+			name: "exec form running bash",
+			raw: `FROM node:20
+RUN ["/bin/bash", "-c", "npm ci"]`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+RUN apk add --no-cache bash
+RUN ["/bin/bash", "-c", "npm ci"]`,
+		},
+		{
+			name: "exec form mentioning bash",
+			raw: `FROM node:20
+SHELL ["/usr/local/bin/bashful"]
+RUN ["sh", "-c", "echo bash"]`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+SHELL ["/usr/local/bin/bashful"]
+RUN ["sh", "-c", "echo bash"]`,
+		},
+		{
+			name: "unconverted base is left alone",
+			raw: `FROM scratch
+RUN source ./env.sh`,
+			expected: `FROM scratch
+RUN source ./env.sh`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			got := converted.String()
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			// Converting again must not add anything
+			reparsed, err := ParseDockerfile(ctx, []byte(got))
+			if err != nil {
+				t.Fatalf("Failed to parse converted Dockerfile: %v", err)
+			}
+			again, err := reparsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert converted Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(got, again.String()); diff != "" {
+				t.Errorf("second conversion not idempotent (-first, +second):\n%s", diff)
+			}
+		})
+	}
+}
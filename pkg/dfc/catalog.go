@@ -0,0 +1,220 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OCI image layout related constants
+const (
+	OCILayoutIndex            = "index.json"
+	OCIRefNameAnnotation      = "org.opencontainers.image.ref.name"
+	ContainerdImageAnnotation = "io.containerd.image.name"
+)
+
+// TagCatalog lists the tags available for each Chainguard image, by image name (e.g. node).
+// It is used to check converted tags without access to a registry.
+type TagCatalog map[string][]string
+
+// LoadTagCatalog loads a tag catalog from a YAML or JSON file mapping image names to lists of
+// tags, or from an OCI image layout directory (or a directory of OCI image layouts, one per
+// image), using the reference names of the manifests in index.json
+func LoadTagCatalog(catalogPath string) (TagCatalog, error) {
+	info, err := os.Stat(catalogPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading tag catalog: %w", err)
+	}
+
+	if !info.IsDir() {
+		data, err := os.ReadFile(catalogPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading tag catalog: %w", err)
+		}
+		var catalog TagCatalog
+		if err := yaml.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("parsing tag catalog %s: %w", catalogPath, err)
+		}
+		return catalog, nil
+	}
+
+	catalog := make(TagCatalog)
+	err = walkOCILayouts(catalogPath, func(layout string, manifest ociDescriptor) {
+		if image, tag := ociReference(layout, manifest.Annotations); image != "" && !slices.Contains(catalog[image], tag) {
+			catalog[image] = append(catalog[image], tag)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// walkOCILayouts calls fn for each manifest of an OCI image layout directory, or of each OCI
+// image layout in a directory
+func walkOCILayouts(dir string, fn func(layout string, manifest ociDescriptor)) error {
+	layouts := []string{dir}
+	if _, err := os.Stat(filepath.Join(dir, OCILayoutIndex)); err != nil {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("reading OCI layouts: %w", err)
+		}
+		layouts = nil
+		for _, entry := range entries {
+			layout := filepath.Join(dir, entry.Name())
+			if _, err := os.Stat(filepath.Join(layout, OCILayoutIndex)); entry.IsDir() && err == nil {
+				layouts = append(layouts, layout)
+			}
+		}
+	}
+
+	for _, layout := range layouts {
+		index, err := readOCIIndex(layout)
+		if err != nil {
+			return err
+		}
+		for _, manifest := range index.Manifests {
+			fn(layout, manifest)
+		}
+	}
+	return nil
+}
+
+// readOCIIndex reads the index.json of an OCI image layout
+func readOCIIndex(layout string) (*ociIndex, error) {
+	data, err := os.ReadFile(filepath.Join(layout, OCILayoutIndex))
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI layout: %w", err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("parsing OCI layout %s: %w", layout, err)
+	}
+	return &index, nil
+}
+
+// ociReference returns the image name and tag of a manifest of an OCI image layout. The reference
+// name is either a full reference (cgr.dev/chainguard/node:20) or just a tag, in which case the
+// image name comes from the containerd annotation, or from the name of the layout directory.
+func ociReference(layout string, annotations map[string]string) (image, tag string) {
+	refName := annotations[OCIRefNameAnnotation]
+	if refName == "" {
+		return "", ""
+	}
+	if i := strings.LastIndex(refName, ":"); i > strings.LastIndex(refName, "/") {
+		return path.Base(refName[:i]), refName[i+1:]
+	}
+	if name := annotations[ContainerdImageAnnotation]; name != "" {
+		repo, _, _ := strings.Cut(name, "@")
+		if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+			repo = repo[:i]
+		}
+		return path.Base(repo), refName
+	}
+	return filepath.Base(layout), refName
+}
+
+// checkTag checks that a tag of an image is in the catalog. If it is not, it returns the nearest
+// available tag and a note explaining the change, or the tag unchanged and a note if there is no
+// similar tag. Tags containing ARG variables are not checked.
+func (c TagCatalog) checkTag(image, tag string) (string, string) {
+	if len(c) == 0 || strings.Contains(tag, "$") {
+		return tag, ""
+	}
+	tags, ok := c[image]
+	if !ok {
+		return tag, fmt.Sprintf("image %s is not in the tag catalog", image)
+	}
+	if slices.Contains(tags, tag) {
+		return tag, ""
+	}
+	if nearest := nearestTag(tag, tags); nearest != "" {
+		return nearest, fmt.Sprintf("tag %s:%s is not available, using the nearest available tag %s", image, tag, nearest)
+	}
+	return tag, fmt.Sprintf("tag %s:%s is not available, and there is no similar tag in the tag catalog", image, tag)
+}
+
+// versionedTag is a tag split into a prefix (e.g. openjdk-), a version and whether it is a -dev tag
+type versionedTag struct {
+	Prefix  string
+	Version []int
+	Dev     bool
+}
+
+// parseVersionedTag splits a tag like openjdk-17.0-dev into its prefix, version and -dev suffix
+func parseVersionedTag(tag string) versionedTag {
+	var t versionedTag
+	tag, t.Dev = strings.CutSuffix(tag, "-dev")
+	i := len(tag)
+	for i > 0 && (tag[i-1] == '.' || (tag[i-1] >= '0' && tag[i-1] <= '9')) {
+		i--
+	}
+	t.Prefix = tag[:i]
+	for _, part := range strings.Split(strings.Trim(tag[i:], "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			t.Version = nil
+			break
+		}
+		t.Version = append(t.Version, n)
+	}
+	return t
+}
+
+// nearestTag returns the available tag nearest to the wanted tag: a tag with the same prefix and
+// -dev suffix whose version first differs at the latest possible component, by as little as
+// possible, preferring newer versions. Unversioned tags fall back to latest (or latest-dev).
+func nearestTag(tag string, available []string) string {
+	want := parseVersionedTag(tag)
+	if len(want.Version) == 0 {
+		latest := "latest"
+		if want.Dev {
+			latest = DefaultImageTag
+		}
+		if slices.Contains(available, latest) {
+			return latest
+		}
+		return ""
+	}
+
+	var nearest string
+	var nearestDepth, nearestDistance, nearestDiff int
+	for _, candidate := range available {
+		got := parseVersionedTag(candidate)
+		if got.Prefix != want.Prefix || got.Dev != want.Dev || len(got.Version) == 0 {
+			continue
+		}
+
+		// Depth is the number of leading components in common
+		depth := 0
+		for depth < len(want.Version) && depth < len(got.Version) && want.Version[depth] == got.Version[depth] {
+			depth++
+		}
+		diff := 0
+		if depth < len(want.Version) && depth < len(got.Version) {
+			diff = got.Version[depth] - want.Version[depth]
+		}
+		distance := max(diff, -diff)
+
+		better := nearest == "" ||
+			depth > nearestDepth ||
+			(depth == nearestDepth && distance < nearestDistance) ||
+			(depth == nearestDepth && distance == nearestDistance && diff > nearestDiff)
+		if better {
+			nearest, nearestDepth, nearestDistance, nearestDiff = candidate, depth, distance, diff
+		}
+	}
+	return nearest
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNearestTag(t *testing.T) {
+	available := []string{"latest", "latest-dev", "18", "18-dev", "20", "20-dev", "22", "3.10", "3.12", "3.12-dev", "openjdk-17", "openjdk-21"}
+
+	testCases := []struct {
+		tag      string
+		expected string
+	}{
+		{tag: "14-dev", expected: "18-dev"},
+		{tag: "19", expected: "20"},
+		{tag: "25", expected: "22"},
+		{tag: "3.11", expected: "3.12"},
+		{tag: "3.9-dev", expected: "3.12-dev"},
+		{tag: "openjdk-11", expected: "openjdk-17"},
+		{tag: "bookworm", expected: "latest"},
+		{tag: "bookworm-dev", expected: "latest-dev"},
+		{tag: "openjre-17", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.tag, func(t *testing.T) {
+			if got := nearestTag(tc.tag, available); got != tc.expected {
+				t.Errorf("nearestTag(%q) = %q, want %q", tc.tag, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestLoadTagCatalog(t *testing.T) {
+	dir := t.TempDir()
+
+	// YAML (or JSON) file
+	catalogFile := filepath.Join(dir, "catalog.yaml")
+	if err := os.WriteFile(catalogFile, []byte("node:\n  - \"20\"\n  - 20-dev\npython: [\"3.12\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := LoadTagCatalog(catalogFile)
+	if err != nil {
+		t.Fatalf("LoadTagCatalog() error = %v", err)
+	}
+	if diff := cmp.Diff(TagCatalog{"node": {"20", "20-dev"}, "python": {"3.12"}}, catalog); diff != "" {
+		t.Errorf("LoadTagCatalog() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Directory of OCI layouts, with full references, tags with the containerd image name, and bare tags
+	writeLayout := func(name, index string) {
+		layout := filepath.Join(dir, "layouts", name)
+		if err := os.MkdirAll(layout, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(layout, OCILayoutIndex), []byte(index), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLayout("node", `{"schemaVersion": 2, "manifests": [
+		{"digest": "sha256:aaaa", "annotations": {"org.opencontainers.image.ref.name": "cgr.dev/chainguard/node:22"}},
+		{"digest": "sha256:bbbb", "annotations": {"org.opencontainers.image.ref.name": "22-dev", "io.containerd.image.name": "cgr.dev/chainguard/node:22-dev"}}
+	]}`)
+	writeLayout("go", `{"schemaVersion": 2, "manifests": [
+		{"digest": "sha256:cccc", "annotations": {"org.opencontainers.image.ref.name": "1.24"}},
+		{"digest": "sha256:dddd"}
+	]}`)
+	catalog, err = LoadTagCatalog(filepath.Join(dir, "layouts"))
+	if err != nil {
+		t.Fatalf("LoadTagCatalog() error = %v", err)
+	}
+	if diff := cmp.Diff(TagCatalog{"node": {"22", "22-dev"}, "go": {"1.24"}}, catalog); diff != "" {
+		t.Errorf("LoadTagCatalog() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := LoadTagCatalog(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("Expected error for missing tag catalog")
+	}
+}
+
+func TestTagCatalogConversion(t *testing.T) {
+	raw := `ARG GO_IMAGE=golang:1.19
+FROM ${GO_IMAGE} AS build
+RUN go build -o /app
+
+FROM node:14
+RUN npm ci
+
+FROM python:3.12-slim
+COPY --from=nginx:1.25 /etc/nginx/nginx.conf /etc/nginx/`
+	expected := `ARG GO_IMAGE=cgr.dev/ORG/go:1.24-dev
+FROM ${GO_IMAGE} AS build
+RUN go build -o /app
+
+FROM cgr.dev/ORG/node:18-dev
+RUN npm ci
+
+FROM cgr.dev/ORG/python:3.12
+COPY --from=cgr.dev/ORG/nginx:1.25 /etc/nginx/nginx.conf /etc/nginx/
+`
+	expectedNotes := []string{
+		"tag go:1.19-dev is not available, using the nearest available tag 1.24-dev",
+		"tag node:14-dev is not available, using the nearest available tag 18-dev",
+		"image nginx is not in the tag catalog",
+	}
+
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{
+		TagCatalog: TagCatalog{
+			"go":     {"1.24", "1.24-dev"},
+			"node":   {"18", "18-dev", "20", "20-dev"},
+			"python": {"3.12", "3.12-dev"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(expected, converted.String()); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+
+	var notes []string
+	for _, line := range converted.Lines {
+		for _, note := range line.Notes {
+			if strings.HasPrefix(note, "tag ") || strings.HasPrefix(note, "image ") {
+				notes = append(notes, note)
+			}
+		}
+	}
+	if diff := cmp.Diff(expectedNotes, notes); diff != "" {
+		t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// VersionPlaceholder is replaced by the version of the converted tag (e.g. 3.12 for 3.12-dev) in the
+// packages listed in the image contents and in rewritten paths. Entries using it are skipped for
+// unversioned tags.
+const VersionPlaceholder = "${version}"
+
+// defaultImageContents are the packages shipped by the -dev variants of common Chainguard images,
+// which are used for the images without contents in the mappings
+var defaultImageContents = map[string][]string{
+	"python": {"python-" + VersionPlaceholder, "python-3", "py3-pip"},
+	"node":   {"nodejs-" + VersionPlaceholder, "nodejs", "npm"},
+	"go":     {"go-" + VersionPlaceholder, "go"},
+	"jdk":    {"openjdk-" + VersionPlaceholder, "openjdk-" + VersionPlaceholder + "-default-jdk"},
+	"ruby":   {"ruby-" + VersionPlaceholder, "ruby-3"},
+}
+
+// tagVersion matches the version in a converted tag
+var tagVersion = regexp.MustCompile(`\d+(?:\.\d+)*`)
+
+// versionedPackage matches the names of packages with a version stream, like python-3.12
+var versionedPackage = regexp.MustCompile(`^(.+)-(\d+(?:\.\d+)*)$`)
+
+// imageContents are the packages shipped by the image of a stage
+type imageContents struct {
+	Image    string   // Chainguard image name
+	Packages []string // Packages in the image, with the version of the tag filled in
+}
+
+// resolveImageContents returns the packages shipped by a converted image, from the contents
+// in the mappings (by image:tag, then by image) or the built-in defaults
+func resolveImageContents(image, tag string, contents map[string][]string) imageContents {
+	packages, ok := contents[image+":"+tag]
+	if !ok {
+		packages, ok = contents[image+":"+strings.TrimSuffix(tag, "-dev")]
+	}
+	if !ok {
+		packages, ok = contents[image]
+	}
+	if !ok {
+		packages = defaultImageContents[image]
+	}
+
+	result := imageContents{Image: image}
+	for _, pkg := range packages {
+		if pkg, ok := expandVersion(pkg, tag); ok {
+			result.Packages = append(result.Packages, pkg)
+		}
+	}
+	return result
+}
+
+// expandVersion replaces VersionPlaceholder with the version of a tag, and reports false if the
+// tag has no version (like latest, or a tag with ARG variables)
+func expandVersion(s, tag string) (string, bool) {
+	if !strings.Contains(s, VersionPlaceholder) {
+		return s, true
+	}
+	version := tagVersion.FindString(tag)
+	if version == "" || strings.Contains(tag, "$") {
+		return s, false
+	}
+	return strings.ReplaceAll(s, VersionPlaceholder, version), true
+}
+
+// dedupe drops the packages already shipped by the image, including other versions of a version
+// stream when they are compatible (python-3 on python-3.12), and returns notes about the dropped
+// packages and the packages conflicting with the version in the image (python-3.11 on python-3.12),
+// which are kept
+func (c imageContents) dedupe(packages []string) ([]string, []string) {
+	if len(c.Packages) == 0 {
+		return packages, nil
+	}
+
+	var kept, dropped, notes []string
+	for _, pkg := range packages {
+		redundant, conflict := c.check(pkg)
+		switch {
+		case redundant:
+			dropped = append(dropped, pkg)
+		case conflict != "":
+			kept = append(kept, pkg)
+			notes = append(notes, fmt.Sprintf("%s conflicts with %s in the %s image", pkg, conflict, c.Image))
+		default:
+			kept = append(kept, pkg)
+		}
+	}
+	if len(dropped) > 0 {
+		notes = append([]string{fmt.Sprintf("dropped %s: already in the %s image", strings.Join(dropped, ", "), c.Image)}, notes...)
+	}
+	return kept, notes
+}
+
+// check returns whether a package is shipped by the image, or else the package of the image with
+// a conflicting version of the same version stream
+func (c imageContents) check(pkg string) (bool, string) {
+	if slices.Contains(c.Packages, pkg) {
+		return true, ""
+	}
+	match := versionedPackage.FindStringSubmatch(pkg)
+	if match == nil {
+		return false, ""
+	}
+	for _, shipped := range c.Packages {
+		shippedMatch := versionedPackage.FindStringSubmatch(shipped)
+		if shippedMatch == nil || shippedMatch[1] != match[1] {
+			continue
+		}
+		if shippedMatch[2] == match[2] || strings.HasPrefix(shippedMatch[2], match[2]+".") {
+			return true, ""
+		}
+		return false, shipped
+	}
+	return false, ""
+}
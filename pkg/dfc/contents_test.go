@@ -0,0 +1,129 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveImageContents(t *testing.T) {
+	contents := map[string][]string{
+		"node":        {"nodejs-${version}", "npm", "yarn"},
+		"python:3.10": {"python-3.10", "py3.10-pip"},
+	}
+
+	testCases := []struct {
+		image    string
+		tag      string
+		expected []string
+	}{
+		{image: "python", tag: "3.12-dev", expected: []string{"python-3.12", "python-3", "py3-pip"}},
+		{image: "python", tag: "latest-dev", expected: []string{"python-3", "py3-pip"}},
+		{image: "python", tag: "3.10-dev", expected: []string{"python-3.10", "py3.10-pip"}},
+		{image: "node", tag: "20-dev", expected: []string{"nodejs-20", "npm", "yarn"}},
+		{image: "jdk", tag: "openjdk-17-dev", expected: []string{"openjdk-17", "openjdk-17-default-jdk"}},
+		{image: "go", tag: "${GO_VERSION}-dev", expected: []string{"go"}},
+		{image: "nginx", tag: "latest", expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.image+":"+tc.tag, func(t *testing.T) {
+			got := resolveImageContents(tc.image, tc.tag, contents)
+			if diff := cmp.Diff(tc.expected, got.Packages); diff != "" {
+				t.Errorf("resolveImageContents() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPackageDeduplication(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		mappings      MappingsConfig
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "redundant packages",
+			raw: `FROM python:3.12
+RUN apt-get update && apt-get install -y python3 python3-pip git`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache git
+`,
+			expectedNotes: []string{"dropped py3-pip, python-3: already in the python image"},
+		},
+		{
+			name: "only redundant packages",
+			raw: `FROM node:20
+RUN apt-get update && apt-get install -y nodejs npm`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+RUN true
+`,
+			expectedNotes: []string{"dropped nodejs, npm: already in the node image"},
+		},
+		{
+			name: "conflicting version",
+			raw: `FROM python:3.12
+RUN apt-get update && apt-get install -y python-3.11 python-3.12`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache python-3.11
+`,
+			expectedNotes: []string{
+				"dropped python-3.12: already in the python image",
+				"python-3.11 conflicts with python-3.12 in the python image",
+			},
+		},
+		{
+			name: "contents from mappings, inherited by child stage",
+			raw: `FROM php:8.3 AS base
+
+FROM base
+RUN apt-get update && apt-get install -y composer curl`,
+			mappings: MappingsConfig{Contents: map[string][]string{"php": {"php-${version}", "composer"}}},
+			expected: `FROM cgr.dev/ORG/php:8.3-dev AS base
+USER root
+
+FROM base
+RUN apk add --no-cache curl
+`,
+			expectedNotes: []string{"dropped composer: already in the php image"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{ExtraMappings: tc.mappings})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.Run != nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
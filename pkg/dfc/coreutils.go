@@ -0,0 +1,429 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"regexp"
+	"strings"
+)
+
+// GNU utilities that busybox provides with a reduced set of options (see also CommandChPasswd)
+const (
+	CommandSed      = "sed"
+	CommandGrep     = "grep"
+	CommandFind     = "find"
+	CommandXargs    = "xargs"
+	CommandStat     = "stat"
+	CommandCp       = "cp"
+	CommandDate     = "date"
+	CommandReadlink = "readlink"
+)
+
+// Packages providing the GNU versions of busybox applets
+const (
+	PackageCoreutils = "coreutils"
+	PackageFindutils = "findutils"
+	PackageGrep      = "grep"
+	PackageSed       = "sed"
+)
+
+// gnuOption describes the busybox equivalent of a GNU option
+type gnuOption struct {
+	Busybox   string // Busybox option(s) separated by spaces, empty to drop the option
+	Value     bool   // The option takes a value (as --opt=value or --opt value)
+	Attach    bool   // The value is attached to the busybox option (e.g. sed -i.bak)
+	DropValue bool   // The value has no busybox equivalent and is dropped
+	Default   string // Value of an optional value, which can then only be attached (e.g. --replace[=R])
+}
+
+// gnuCommand describes how a GNU command maps onto its busybox applet
+type gnuCommand struct {
+	Command        string
+	Package        string               // Package providing the GNU version of the command
+	Options        map[string]gnuOption // GNU options and their busybox equivalents
+	Unsupported    []string             // Options without a busybox equivalent
+	ShortValues    string               // Short options which take a value
+	OptionalValues string               // Short options which take an optional attached value (e.g. sed -i.bak)
+	StrictLong     bool                 // Unknown --long options are treated as unsupported
+	StopAtOperand  bool                 // Options end at the first operand (e.g. the command run by xargs)
+	Check          func(args []string) bool
+}
+
+// gnuCommands is the table of GNU (and shadow) commands converted to busybox syntax
+var gnuCommands = []gnuCommand{
+	{
+		Command: CommandSed,
+		Package: PackageSed,
+		Options: map[string]gnuOption{
+			"--in-place":        {Busybox: "-i", Attach: true},
+			"--regexp-extended": {Busybox: "-E"},
+			"--expression":      {Busybox: "-e", Value: true},
+			"--file":            {Busybox: "-f", Value: true},
+			"--quiet":           {Busybox: "-n"},
+			"--silent":          {Busybox: "-n"},
+			"--posix":           {},
+		},
+		Unsupported: []string{"-l", "-s", "-u", "-z", "--debug", "--follow-symlinks", "--line-length",
+			"--null-data", "--sandbox", "--separate", "--unbuffered", "--zero-terminated"},
+		ShortValues:    "efl",
+		OptionalValues: "i",
+		StrictLong:     true,
+	},
+	{
+		Command: CommandGrep,
+		Package: PackageGrep,
+		Options: map[string]gnuOption{
+			"--extended-regexp":       {Busybox: "-E"},
+			"--fixed-strings":         {Busybox: "-F"},
+			"--basic-regexp":          {},
+			"--ignore-case":           {Busybox: "-i"},
+			"--invert-match":          {Busybox: "-v"},
+			"--word-regexp":           {Busybox: "-w"},
+			"--line-regexp":           {Busybox: "-x"},
+			"--count":                 {Busybox: "-c"},
+			"--quiet":                 {Busybox: "-q"},
+			"--silent":                {Busybox: "-q"},
+			"--no-messages":           {Busybox: "-s"},
+			"--files-with-matches":    {Busybox: "-l"},
+			"--files-without-match":   {Busybox: "-L"},
+			"--line-number":           {Busybox: "-n"},
+			"--with-filename":         {Busybox: "-H"},
+			"--no-filename":           {Busybox: "-h"},
+			"--only-matching":         {Busybox: "-o"},
+			"--recursive":             {Busybox: "-r"},
+			"--dereference-recursive": {Busybox: "-R"},
+			"--regexp":                {Busybox: "-e", Value: true},
+			"--file":                  {Busybox: "-f", Value: true},
+			"--max-count":             {Busybox: "-m", Value: true},
+			"--after-context":         {Busybox: "-A", Value: true},
+			"--before-context":        {Busybox: "-B", Value: true},
+			"--context":               {Busybox: "-C", Value: true},
+		},
+		Unsupported: []string{"-P", "-T", "-U", "-Z", "--perl-regexp"},
+		ShortValues: "efmABC",
+		StrictLong:  true,
+	},
+	{
+		Command: CommandFind,
+		Package: PackageFindutils,
+		Options: map[string]gnuOption{
+			"-noleaf": {},
+		},
+		Unsupported: []string{"-daystart", "-fls", "-fprint", "-fprint0", "-fprintf", "-printf",
+			"-readable", "-regextype", "-writable", "-xtype"},
+	},
+	{
+		Command: CommandXargs,
+		Package: PackageFindutils,
+		Options: map[string]gnuOption{
+			"--no-run-if-empty": {Busybox: "-r"},
+			"--null":            {Busybox: "-0"},
+			"--verbose":         {Busybox: "-t"},
+			"--interactive":     {Busybox: "-p"},
+			"--exit":            {Busybox: "-x"},
+			"--max-args":        {Busybox: "-n", Value: true},
+			"--max-procs":       {Busybox: "-P", Value: true},
+			"--max-chars":       {Busybox: "-s", Value: true},
+			"--arg-file":        {Busybox: "-a", Value: true},
+			"--delimiter":       {Busybox: "-d", Value: true},
+			"--replace":         {Busybox: "-I", Value: true, Default: "{}"},
+			"-i":                {Busybox: "-I", Value: true, Default: "{}"},
+		},
+		Unsupported:   []string{"-L", "-l", "-o", "--max-lines", "--open-tty", "--process-slot-var", "--show-limits"},
+		ShortValues:   "nPsadIEL",
+		StrictLong:    true,
+		StopAtOperand: true,
+	},
+	{
+		Command: CommandStat,
+		Package: PackageCoreutils,
+		Options: map[string]gnuOption{
+			"--format":      {Busybox: "-c", Value: true},
+			"--dereference": {Busybox: "-L"},
+			"--file-system": {Busybox: "-f"},
+			"--terse":       {Busybox: "-t"},
+		},
+		Unsupported: []string{"--cached", "--printf"},
+		ShortValues: "c",
+		StrictLong:  true,
+		Check:       statFormatSupported,
+	},
+	{
+		Command: CommandCp,
+		Package: PackageCoreutils,
+		Options: map[string]gnuOption{
+			"--recursive":              {Busybox: "-R"},
+			"--archive":                {Busybox: "-a"},
+			"--force":                  {Busybox: "-f"},
+			"--interactive":            {Busybox: "-i"},
+			"--link":                   {Busybox: "-l"},
+			"--dereference":            {Busybox: "-L"},
+			"--no-dereference":         {Busybox: "-P"},
+			"--no-clobber":             {Busybox: "-n"},
+			"--symbolic-link":          {Busybox: "-s"},
+			"--update":                 {Busybox: "-u"},
+			"--verbose":                {Busybox: "-v"},
+			"--one-file-system":        {Busybox: "-x"},
+			"--target-directory":       {Busybox: "-t", Value: true},
+			"--preserve":               {Busybox: "-p", DropValue: true},
+			"--no-preserve":            {DropValue: true},
+			"--reflink":                {DropValue: true},
+			"--sparse":                 {DropValue: true},
+			"--strip-trailing-slashes": {},
+		},
+		Unsupported: []string{"-b", "-S", "-T", "-Z", "--backup", "--context", "--no-target-directory",
+			"--parents", "--remove-destination", "--suffix"},
+		ShortValues: "St",
+		StrictLong:  true,
+	},
+	{
+		Command: CommandDate,
+		Package: PackageCoreutils,
+		Options: map[string]gnuOption{
+			"--date":      {Busybox: "-d", Value: true},
+			"--reference": {Busybox: "-r", Value: true},
+			"--set":       {Busybox: "-s", Value: true},
+			"--utc":       {Busybox: "-u"},
+			"--universal": {Busybox: "-u"},
+			"--rfc-email": {Busybox: "-R"},
+			"--rfc-2822":  {Busybox: "-R"},
+			"--iso-8601":  {Busybox: "-I", Attach: true},
+		},
+		Unsupported: []string{"-f", "--debug", "--file", "--rfc-3339"},
+		ShortValues: "drs",
+		StrictLong:  true,
+		Check:       dateInputSupported,
+	},
+	{
+		Command: CommandChPasswd,
+		Package: PackageShadow,
+		Options: map[string]gnuOption{
+			"--encrypted":    {Busybox: "-e"},
+			"--md5":          {Busybox: "-m"},
+			"--crypt-method": {Busybox: "-c", Value: true},
+		},
+		Unsupported: []string{"-s", "-R", "-P", "--sha-rounds", "--root", "--prefix"},
+		ShortValues: "csRP",
+		StrictLong:  true,
+	},
+	{
+		Command: CommandReadlink,
+		Package: PackageCoreutils,
+		Options: map[string]gnuOption{
+			"--canonicalize":          {Busybox: "-f"},
+			"--canonicalize-existing": {Busybox: "-f"},
+			"-e":                      {Busybox: "-f"},
+			"--no-newline":            {Busybox: "-n"},
+			"--quiet":                 {Busybox: "-q"},
+			"--silent":                {Busybox: "-q"},
+			"--verbose":               {Busybox: "-v"},
+		},
+		Unsupported: []string{"-m", "-z", "--canonicalize-missing", "--zero"},
+		StrictLong:  true,
+	},
+}
+
+// splitOption splits a --name=value argument
+func splitOption(arg string) (name, value string, hasValue bool) {
+	if strings.HasPrefix(arg, "--") {
+		return strings.Cut(arg, "=")
+	}
+	return arg, "", false
+}
+
+// isOperand checks if an argument is an operand rather than an option
+func isOperand(arg string) bool {
+	return !strings.HasPrefix(arg, "-") || arg == "-"
+}
+
+// convert rewrites the GNU options of a command to their busybox equivalents
+func (c gnuCommand) convert(part *ShellPart) *ShellPart {
+	result := cloneShellPart(part)
+	var args []string
+
+	for i := 0; i < len(part.Args); i++ {
+		arg := part.Args[i]
+		if arg == "--" || (c.StopAtOperand && isOperand(arg)) {
+			args = append(args, part.Args[i:]...)
+			break
+		}
+
+		name, value, hasValue := splitOption(arg)
+		opt, ok := c.Options[name]
+		if !ok {
+			args = append(args, arg)
+			// Keep the value of short options which take one
+			if c.takesShortValue(arg) && i+1 < len(part.Args) {
+				args = append(args, part.Args[i+1])
+				i++
+			}
+			continue
+		}
+
+		// The value of a long option may be in the next argument
+		if opt.Value && !hasValue && strings.HasPrefix(arg, "--") && opt.Default == "" && i+1 < len(part.Args) {
+			value, hasValue = part.Args[i+1], true
+			i++
+		}
+		if opt.Value && !hasValue && opt.Default != "" {
+			value, hasValue = opt.Default, true
+		}
+
+		if opt.Busybox == "" {
+			continue
+		}
+		switch {
+		case hasValue && opt.Attach:
+			args = append(args, opt.Busybox+value)
+		case hasValue && !opt.DropValue:
+			args = append(args, strings.Fields(opt.Busybox)...)
+			args = append(args, value)
+		default:
+			args = append(args, strings.Fields(opt.Busybox)...)
+		}
+	}
+
+	result.Args = args
+	return result
+}
+
+// takesShortValue checks if a short option (or the last option of a group) is followed by a value
+func (c gnuCommand) takesShortValue(arg string) bool {
+	if strings.HasPrefix(arg, "--") || len(arg) != 2 || arg[0] != '-' {
+		return false
+	}
+	return strings.ContainsRune(c.ShortValues, rune(arg[1]))
+}
+
+// needsGNU checks if a command uses options that busybox does not support
+func (c gnuCommand) needsGNU(part *ShellPart) bool {
+	for i := 0; i < len(part.Args); i++ {
+		arg := part.Args[i]
+		if arg == "--" || (c.StopAtOperand && isOperand(arg)) {
+			break
+		}
+		if isOperand(arg) {
+			continue
+		}
+
+		name, _, hasValue := splitOption(arg)
+		for _, unsupported := range c.Unsupported {
+			if name == unsupported {
+				return true
+			}
+			// Short options may be grouped, as in grep -rP
+			if len(unsupported) == 2 && !strings.HasPrefix(arg, "--") &&
+				strings.ContainsRune(arg[1:], rune(unsupported[1])) && !c.groupHasValueBefore(arg, unsupported[1]) {
+				return true
+			}
+		}
+
+		if opt, ok := c.Options[name]; ok {
+			if opt.Value && !hasValue && strings.HasPrefix(arg, "--") && opt.Default == "" {
+				i++
+			}
+			continue
+		}
+		if strings.HasPrefix(arg, "--") && c.StrictLong {
+			return true
+		}
+		if c.takesShortValue(arg) {
+			i++
+		}
+	}
+
+	if c.Check != nil && !c.Check(part.Args) {
+		return true
+	}
+	return false
+}
+
+// groupHasValueBefore checks if a short option group contains an option taking a value before the
+// given option, in which case the rest of the group is that value (e.g. sed -es/a/b/)
+func (c gnuCommand) groupHasValueBefore(arg string, option byte) bool {
+	for j := 1; j < len(arg); j++ {
+		if arg[j] == option {
+			return false
+		}
+		if strings.IndexByte(c.ShortValues+c.OptionalValues, arg[j]) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// optionValues returns the values given to a command option in its short or long form
+func optionValues(args []string, short, long string) []string {
+	var values []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == short || arg == long:
+			if i+1 < len(args) {
+				values = append(values, args[i+1])
+				i++
+			}
+		case strings.HasPrefix(arg, long+"="):
+			values = append(values, strings.TrimPrefix(arg, long+"="))
+		case strings.HasPrefix(arg, short) && len(arg) > len(short) && !strings.HasPrefix(arg, "--"):
+			values = append(values, arg[len(short):])
+		}
+	}
+	return values
+}
+
+// unquote removes the quotes around a shell word
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// statUnsupportedFormatRegex matches the stat format sequences that busybox does not know about
+var statUnsupportedFormatRegex = regexp.MustCompile(`%[wWm]`)
+
+// statFormatSupported checks the stat format strings for sequences busybox does not support
+func statFormatSupported(args []string) bool {
+	for _, format := range optionValues(args, "-c", "--format") {
+		if statUnsupportedFormatRegex.MatchString(unquote(format)) {
+			return false
+		}
+	}
+	return true
+}
+
+// busyboxDateRegex matches the date formats that busybox date -d can parse
+var busyboxDateRegex = regexp.MustCompile(`^(@-?\d+|\d{1,2}:\d{2}(:\d{2})?|\d{4}-\d{2}-\d{2}([ T]\d{1,2}:\d{2}(:\d{2})?)?|(\d{4}\.)?\d{2}\.\d{2}-\d{1,2}:\d{2}(:\d{2})?|\d{8}(\d{2}|\d{4})?(\.\d{2})?)$`)
+
+// dateInputSupported checks that the date given to date -d can be parsed by busybox.
+// Dates coming from variables or command substitution cannot be checked and are assumed to be fine.
+func dateInputSupported(args []string) bool {
+	for _, input := range optionValues(args, "-d", "--date") {
+		input = unquote(input)
+		if strings.ContainsAny(input, "$`") {
+			continue
+		}
+		if !busyboxDateRegex.MatchString(input) {
+			return false
+		}
+	}
+	return true
+}
+
+// gnuCommandHandlers returns the command handlers for the GNU commands in gnuCommands
+func gnuCommandHandlers() []CommandHandler {
+	handlers := make([]CommandHandler, 0, len(gnuCommands))
+	for _, c := range gnuCommands {
+		handlers = append(handlers, CommandHandler{
+			Command:      c.Command,
+			Converter:    c.convert,
+			Package:      c.Package,
+			NeedsPackage: c.needsGNU,
+		})
+	}
+	return handlers
+}
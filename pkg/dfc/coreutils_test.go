@@ -0,0 +1,255 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGNUCommandConversion(t *testing.T) {
+	testCases := []struct {
+		name            string
+		command         string
+		args            []string
+		expectedArgs    []string
+		expectedPackage string
+	}{
+		{
+			name:         "sed long options",
+			command:      CommandSed,
+			args:         []string{"--in-place=.bak", "--regexp-extended", "--expression", "'s/a/b/'", "file"},
+			expectedArgs: []string{"-i.bak", "-E", "-e", "'s/a/b/'", "file"},
+		},
+		{
+			name:         "sed in-place suffix is not mistaken for options",
+			command:      CommandSed,
+			args:         []string{"-i.bus", "s/a/b/", "file"},
+			expectedArgs: []string{"-i.bus", "s/a/b/", "file"},
+		},
+		{
+			name:            "sed follow symlinks",
+			command:         CommandSed,
+			args:            []string{"-i", "--follow-symlinks", "s/a/b/", "file"},
+			expectedPackage: PackageSed,
+		},
+		{
+			name:            "grep perl regexp",
+			command:         CommandGrep,
+			args:            []string{"-oP", `'(?<=v)\d+'`, "file"},
+			expectedPackage: PackageGrep,
+		},
+		{
+			name:         "grep pattern is not mistaken for options",
+			command:      CommandGrep,
+			args:         []string{"-e", "-P", "file"},
+			expectedArgs: []string{"-e", "-P", "file"},
+		},
+		{
+			name:         "grep long options",
+			command:      CommandGrep,
+			args:         []string{"--ignore-case", "--max-count=1", "pattern", "file"},
+			expectedArgs: []string{"-i", "-m", "1", "pattern", "file"},
+		},
+		{
+			name:            "grep unknown long option",
+			command:         CommandGrep,
+			args:            []string{"--include=*.go", "-r", "pattern", "."},
+			expectedPackage: PackageGrep,
+		},
+		{
+			name:            "find printf",
+			command:         CommandFind,
+			args:            []string{".", "-type", "f", "-printf", `"%p\n"`},
+			expectedPackage: PackageFindutils,
+		},
+		{
+			name:         "find noleaf",
+			command:      CommandFind,
+			args:         []string{"/", "-noleaf", "-name", "x"},
+			expectedArgs: []string{"/", "-name", "x"},
+		},
+		{
+			name:         "xargs options stop at command",
+			command:      CommandXargs,
+			args:         []string{"--no-run-if-empty", "--null", "-n", "1", "rm", "--force", "-L"},
+			expectedArgs: []string{"-r", "-0", "-n", "1", "rm", "--force", "-L"},
+		},
+		{
+			name:         "xargs replace",
+			command:      CommandXargs,
+			args:         []string{"-i", "cp", "{}", "/dest"},
+			expectedArgs: []string{"-I", "{}", "cp", "{}", "/dest"},
+		},
+		{
+			name:         "xargs replace string",
+			command:      CommandXargs,
+			args:         []string{"--replace=FILE", "cp", "FILE", "/dest"},
+			expectedArgs: []string{"-I", "FILE", "cp", "FILE", "/dest"},
+		},
+		{
+			name:         "xargs replace without string",
+			command:      CommandXargs,
+			args:         []string{"--replace", "cp", "{}", "/dest"},
+			expectedArgs: []string{"-I", "{}", "cp", "{}", "/dest"},
+		},
+		{
+			name:            "xargs max lines",
+			command:         CommandXargs,
+			args:            []string{"-L", "1", "echo"},
+			expectedPackage: PackageFindutils,
+		},
+		{
+			name:         "stat format",
+			command:      CommandStat,
+			args:         []string{"--format=%a", "file"},
+			expectedArgs: []string{"-c", "%a", "file"},
+		},
+		{
+			name:            "stat birth time",
+			command:         CommandStat,
+			args:            []string{"-c", `"%W"`, "file"},
+			expectedPackage: PackageCoreutils,
+		},
+		{
+			name:            "cp parents",
+			command:         CommandCp,
+			args:            []string{"--parents", "a/b", "/dest"},
+			expectedPackage: PackageCoreutils,
+		},
+		{
+			name:         "cp long options",
+			command:      CommandCp,
+			args:         []string{"--archive", "--preserve=mode,ownership", "--reflink=auto", "src", "dst"},
+			expectedArgs: []string{"-a", "-p", "src", "dst"},
+		},
+		{
+			name:            "date relative",
+			command:         CommandDate,
+			args:            []string{"-d", `"2 days ago"`, "+%F"},
+			expectedPackage: PackageCoreutils,
+		},
+		{
+			name:         "date absolute",
+			command:      CommandDate,
+			args:         []string{"--utc", `--date="2024-01-31 10:00"`, "+%s"},
+			expectedArgs: []string{"-u", "-d", `"2024-01-31 10:00"`, "+%s"},
+		},
+		{
+			name:         "date from variable",
+			command:      CommandDate,
+			args:         []string{"-d", `"$BUILD_DATE"`},
+			expectedArgs: []string{"-d", `"$BUILD_DATE"`},
+		},
+		{
+			name:         "readlink canonicalize existing",
+			command:      CommandReadlink,
+			args:         []string{"-e", "/usr/bin/java"},
+			expectedArgs: []string{"-f", "/usr/bin/java"},
+		},
+		{
+			name:            "readlink canonicalize missing",
+			command:         CommandReadlink,
+			args:            []string{"--canonicalize-missing", "/opt/app"},
+			expectedPackage: PackageCoreutils,
+		},
+	}
+
+	handlers := map[string]CommandHandler{}
+	for _, handler := range gnuCommandHandlers() {
+		handlers[handler.Command] = handler
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := handlers[tc.command]
+			part := &ShellPart{Command: tc.command, Args: tc.args}
+
+			if needsPackage := handler.NeedsPackage(part); needsPackage != (tc.expectedPackage != "") {
+				t.Fatalf("NeedsPackage() = %v, expected package %q", needsPackage, tc.expectedPackage)
+			}
+			if tc.expectedPackage != "" {
+				if handler.Package != tc.expectedPackage {
+					t.Errorf("Package = %q, want %q", handler.Package, tc.expectedPackage)
+				}
+				return
+			}
+
+			got := handler.Converter(part)
+			if diff := cmp.Diff(tc.expectedArgs, got.Args); diff != "" {
+				t.Errorf("Converter() args mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGNUCommandPackages(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name: "package added to existing apk add",
+			raw: `FROM debian:12
+RUN apt-get update && apt-get install -y curl && curl -s https://example.com | grep -oP 'v\d+'`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache curl grep && \
+    curl -s https://example.com | grep -oP 'v\d+'
+`,
+		},
+		{
+			name: "package inserted before the command",
+			raw: `FROM debian:12
+RUN mkdir /dest && cp --parents a/b /dest`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN mkdir /dest && \
+    apk add --no-cache coreutils && \
+    cp --parents a/b /dest
+`,
+		},
+		{
+			name: "GNU version already installed",
+			raw: `FROM debian:12
+RUN apt-get update && apt-get install -y grep
+RUN grep --perl-regexp 'v\d+' file`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache grep
+RUN grep --perl-regexp 'v\d+' file`,
+		},
+		{
+			name: "commands in pipelines are converted",
+			raw: `FROM debian:12
+RUN find . -name '*.tmp' | xargs --no-run-if-empty rm`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN find . -name '*.tmp' | xargs -r rm
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
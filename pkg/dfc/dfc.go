@@ -8,10 +8,10 @@ package dfc
 import (
 	"context"
 	"fmt"
-	"path/filepath"
 	"slices"
-	"strconv"
 	"strings"
+
+	"github.com/chainguard-dev/clog"
 )
 
 // Distro represents a Linux distribution
@@ -49,6 +49,11 @@ const (
 	CommandAddUser  = "adduser"
 	CommandGroupAdd = "groupadd"
 	CommandAddGroup = "addgroup"
+	CommandDelGroup = "delgroup"
+	CommandUserMod  = "usermod"
+	CommandGPasswd  = "gpasswd"
+	CommandChPasswd = "chpasswd"
+	CommandPasswd   = "passwd"
 	PackageShadow   = "shadow"
 )
 
@@ -60,11 +65,12 @@ const (
 
 // Dockerfile directives
 const (
-	DirectiveFrom = "FROM"
-	DirectiveRun  = "RUN"
-	DirectiveUser = "USER"
-	DirectiveArg  = "ARG"
-	KeywordAs     = "AS"
+	DirectiveFrom  = "FROM"
+	DirectiveRun   = "RUN"
+	DirectiveUser  = "USER"
+	DirectiveArg   = "ARG"
+	DirectiveShell = "SHELL"
+	KeywordAs      = "AS"
 )
 
 // Default values
@@ -72,6 +78,7 @@ const (
 	DefaultRegistryDomain = "cgr.dev"
 	DefaultImageTag       = "latest-dev"
 	DefaultUser           = "root"
+	DefaultNonRootUser    = "nonroot"
 	DefaultOrg            = "ORG"
 	DefaultChainguardBase = "chainguard-base"
 )
@@ -119,6 +126,7 @@ type DockerfileLine struct {
 	From      *FromDetails `json:"from,omitempty"`
 	Run       *RunDetails  `json:"run,omitempty"`
 	Arg       *ArgDetails  `json:"arg,omitempty"`
+	Notes     []string     `json:"notes,omitempty"` // Explanations of changes made (or needed) during conversion
 }
 
 // ArgDetails holds details about an ARG directive
@@ -256,38 +264,13 @@ func ParseDockerfile(_ context.Context, content []byte) (*Dockerfile, error) {
 			}
 
 			// Parse the image reference
-			var base, tag, digest string
-
-			// Check for digest
-			if digestParts := strings.Split(fromPart, "@"); len(digestParts) > 1 {
-				fromPart = digestParts[0]
-				digest = digestParts[1]
-			}
-
-			// Check for tag
-			if tagParts := strings.Split(fromPart, ":"); len(tagParts) > 1 {
-				base = tagParts[0]
-				tag = tagParts[1]
-			} else {
-				base = fromPart
-			}
+			dockerfileLine.From = parseFromDetails(fromPart)
+			dockerfileLine.From.Alias = alias
+			dockerfileLine.From.Orig = origImageRef
 
 			// Check for parent reference (case-insensitive)
-			var parent int
-			if parentStage, exists := stageAliases[strings.ToLower(base)]; exists {
-				parent = parentStage
-			}
-
-			// Create the FromDetails
-			dockerfileLine.From = &FromDetails{
-				Base:        base,
-				Tag:         tag,
-				Digest:      digest,
-				Alias:       alias,
-				Parent:      parent,
-				BaseDynamic: strings.Contains(base, "$"),
-				TagDynamic:  strings.Contains(tag, "$"),
-				Orig:        origImageRef,
+			if parentStage, exists := stageAliases[strings.ToLower(dockerfileLine.From.Base)]; exists {
+				dockerfileLine.From.Parent = parentStage
 			}
 		}
 
@@ -411,6 +394,29 @@ func ParseDockerfile(_ context.Context, content []byte) (*Dockerfile, error) {
 	return dockerfile, nil
 }
 
+// parseFromDetails parses an image reference into FromDetails
+func parseFromDetails(imageRef string) *FromDetails {
+	from := &FromDetails{Orig: imageRef}
+
+	// Check for digest
+	if digestParts := strings.Split(imageRef, "@"); len(digestParts) > 1 {
+		imageRef = digestParts[0]
+		from.Digest = digestParts[1]
+	}
+
+	// Check for tag
+	if tagParts := strings.Split(imageRef, ":"); len(tagParts) > 1 {
+		from.Base = tagParts[0]
+		from.Tag = tagParts[1]
+	} else {
+		from.Base = imageRef
+	}
+
+	from.BaseDynamic = strings.Contains(from.Base, "$")
+	from.TagDynamic = strings.Contains(from.Tag, "$")
+	return from
+}
+
 // PackageMap maps distros to package mappings
 type PackageMap map[Distro]map[string][]string
 
@@ -474,24 +480,54 @@ type Options struct {
 	NoBuiltIn         bool              // When true, don't use built-in mappings, only ExtraMappings
 	FromLineConverter FromLineConverter // Optional custom converter for FROM lines
 	RunLineConverter  RunLineConverter  // Optional custom converter for RUN lines
+	CommandHandlers   []CommandHandler  // Optional command handlers, replacing built-in handlers for the same command
+	RestoreUser       UserPolicy        // Which stages switch back to their original user after the injected USER root
+	Target            string            // Optional stage to convert (like docker build --target), along with the stages it depends on
+	SplitRuntime      bool              // When true, split single-stage Dockerfiles into a -dev build stage and a minimal runtime stage
+	TagStrategy       TagStrategy       // How much of the original version to keep in converted tags (default: major.minor)
+	TagCatalog        TagCatalog        // Optional tags available for each image, converted tags are checked against it
+	DigestResolver    DigestResolver    // Optional resolver used to pin converted images to digests (image:tag@sha256:...)
+	ConvertScratch    bool              // When true, convert scratch stages that copy in dynamically linked binaries to glibc-dynamic or cc-dynamic
+	NonRoot           bool              // When true, use the nonroot user (65532) instead of creating app users, in USER, --chown and chown
+	PreferApkForTools bool              // When true, install the tools packaged in Wolfi with apk instead of pip, npm -g, gem, cargo or go install
 }
 
-// MappingsConfig represents the structure of builtin-mappings.yaml
-type MappingsConfig struct {
-	Images   map[string]string `yaml:"images"`
-	Packages PackageMap        `yaml:"packages"`
+// withMappings returns the options with the merged mappings, used to convert image references
+func (opts Options) withMappings(mappings MappingsConfig) Options {
+	opts.ExtraMappings = mappings
+	return opts
 }
 
-// parseImageReference extracts base and tag from an image reference
-func parseImageReference(imageRef string) (base, tag string) {
-	// Check for tag
-	if tagParts := strings.Split(imageRef, ":"); len(tagParts) > 1 {
-		base = tagParts[0]
-		tag = tagParts[1]
-	} else {
-		base = imageRef
+// UserPolicy controls which stages switch back from the injected USER root to their original user
+type UserPolicy string
+
+// User policies
+const (
+	UserPolicyNone  UserPolicy = "none"  // Keep running as root (the default)
+	UserPolicyFinal UserPolicy = "final" // Restore the original user in the final stage
+	UserPolicyAll   UserPolicy = "all"   // Restore the original user in every stage
+)
+
+// validate checks that the user policy is known
+func (p UserPolicy) validate() error {
+	switch p {
+	case "", UserPolicyNone, UserPolicyFinal, UserPolicyAll:
+		return nil
 	}
-	return base, tag
+	return fmt.Errorf("invalid user policy %q, must be one of %q, %q or %q", p, UserPolicyNone, UserPolicyFinal, UserPolicyAll)
+}
+
+// MappingsConfig represents the structure of builtin-mappings.yaml
+type MappingsConfig struct {
+	Images     map[string]string        `yaml:"images"`
+	Packages   PackageMap               `yaml:"packages"`
+	Tags       map[string][]TagRule     `yaml:"tags,omitempty"`       // Tag rules by Chainguard image name, or * for all images
+	Contents   map[string][]string      `yaml:"contents,omitempty"`   // Packages shipped by the -dev variant of Chainguard images, by image or image:tag
+	Paths      map[string]PathMap       `yaml:"paths,omitempty"`      // Paths to rewrite in the stages of Chainguard images, by image name, or * for all images
+	Metadata   map[string]ImageMetadata `yaml:"metadata,omitempty"`   // Default entrypoint and shell availability of Chainguard images, by image name
+	Downloads  map[string]string        `yaml:"downloads,omitempty"`  // Packages providing release binaries downloaded with curl or wget, by URL regular expression capturing the version
+	Tools      map[string]ToolMap       `yaml:"tools,omitempty"`      // Packages providing tools installed with pip, npm -g, gem, cargo or go install, by ecosystem
+	Installers map[string]string        `yaml:"installers,omitempty"` // Packages replacing installer scripts piped into a shell (space-separated, empty for a TODO failure), by URL regular expression
 }
 
 // Convert applies the conversion to the Dockerfile and returns a new converted Dockerfile
@@ -511,7 +547,7 @@ func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, er
 		mappings = defaultMappings
 
 		// Merge with the extra mappings if provided
-		if len(opts.ExtraMappings.Images) > 0 || len(opts.ExtraMappings.Packages) > 0 {
+		if !opts.ExtraMappings.isEmpty() {
 			mappings = MergeMappings(defaultMappings, opts.ExtraMappings)
 		}
 	} else {
@@ -527,6 +563,45 @@ func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, er
 		}
 	}
 
+	if err := validateImageMappings(mappings.Images); err != nil {
+		return nil, err
+	}
+	if err := validateTagRules(mappings.Tags); err != nil {
+		return nil, err
+	}
+	if err := validatePathRewrites(mappings.Paths); err != nil {
+		return nil, err
+	}
+	if err := validateDownloads(mappings.Downloads); err != nil {
+		return nil, err
+	}
+	if err := validateInstallers(mappings.Installers); err != nil {
+		return nil, err
+	}
+
+	if err := opts.RestoreUser.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.TagStrategy.validate(); err != nil {
+		return nil, err
+	}
+
+	// Built-in command handlers, overridden or extended by the custom ones
+	if err := validateCommandHandlers(opts.CommandHandlers); err != nil {
+		return nil, err
+	}
+	handlers := commandHandlers(opts.CommandHandlers)
+	downloads := resolveDownloads(mappings.Downloads)
+
+	// Tools installed with language package managers are only converted if requested
+	var tools map[string]ToolMap
+	if opts.PreferApkForTools {
+		tools = mappings.Tools
+		if tools == nil {
+			tools = make(map[string]ToolMap)
+		}
+	}
+
 	// Create a new Dockerfile for the converted content
 	converted := &Dockerfile{
 		Lines: make([]*DockerfileLine, len(d.Lines)),
@@ -540,7 +615,17 @@ func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, er
 	argsUsedAsBase := make(map[string]bool)
 
 	// Track stages with RUN commands for determining if we need -dev suffix
-	stagesWithRunCommands := detectStagesWithRunCommands(d.Lines)
+	graph := d.Stages()
+	if opts.Target != "" {
+		if err := graph.SetTarget(opts.Target); err != nil {
+			return nil, err
+		}
+	}
+	stagesWithRunCommands := graph.stagesWithRun()
+	linkage := stageLinkage(d.Lines, graph)
+
+	// Track the converted image of each stage, for its packages and paths
+	stageImages := make(map[int]convertedImage)
 
 	// First pass: collect all ARG definitions and identify which ones are used as base images
 	identifyArgsUsedAsBaseImages(d.Lines, argNameToDockerfileLine, argsUsedAsBase)
@@ -554,67 +639,96 @@ func (d *Dockerfile) Convert(ctx context.Context, opts Options) (*Dockerfile, er
 			Stage: line.Stage,
 		}
 
+		// Stages not needed for the target are left untouched
+		if !graph.Builds(line.Stage) {
+			newLine.Run = line.Run
+			newLine.Arg = line.Arg
+			if line.From != nil {
+				newLine.From = copyFromDetails(line.From)
+				newLine.Notes = append(newLine.Notes, fmt.Sprintf("stage not needed for target %q, left unchanged", opts.Target))
+			}
+			converted.Lines[i] = newLine
+			continue
+		}
+
 		if line.From != nil {
 			newLine.From = copyFromDetails(line.From)
 
 			// Apply FROM line conversion only for non-dynamic bases
-			if shouldConvertFromLine(line.From) {
+			if shouldConvertFromLine(line.From) || shouldConvertScratch(line.From, linkage[line.Stage], opts) {
 				// Use the merged mappings for conversion
-				optsWithMappings := Options{
-					Organization:      opts.Organization,
-					Registry:          opts.Registry,
-					ExtraMappings:     mappings,
-					FromLineConverter: opts.FromLineConverter,
-					RunLineConverter:  opts.RunLineConverter,
-				}
-				newLine.Converted = convertFromLine(line.From, line.Stage, stagesWithRunCommands, optsWithMappings)
+				var image convertedImage
+				newLine.Converted, image = convertFromLine(ctx, line.From, line.Stage, stagesWithRunCommands, linkage, opts.withMappings(mappings))
+				newLine.Notes = append(newLine.Notes, image.Notes...)
+				logImageMapping(ctx, line.From.Orig, image.Mapping)
+				stageImages[line.Stage] = image
+			} else if image, ok := stageImages[line.From.Parent]; ok {
+				// Stages built on another stage have its packages and paths
+				stageImages[line.Stage] = image
 			}
 		}
 
 		// Handle ARG lines that are used as base images
 		if line.Arg != nil && line.Arg.UsedAsBase && line.Arg.DefaultValue != "" {
 			// Use the merged mappings for conversion
-			optsWithMappings := Options{
-				Organization:      opts.Organization,
-				Registry:          opts.Registry,
-				ExtraMappings:     mappings,
-				FromLineConverter: opts.FromLineConverter,
-				RunLineConverter:  opts.RunLineConverter,
-			}
-			argLine, argDetails := convertArgLine(line.Arg, d.Lines, stagesWithRunCommands, optsWithMappings)
+			argLine, argDetails, image := convertArgLine(ctx, line.Arg, d.Lines, stagesWithRunCommands, opts.withMappings(mappings))
 			newLine.Converted = argLine
 			newLine.Arg = argDetails
+			newLine.Notes = append(newLine.Notes, image.Notes...)
+			logImageMapping(ctx, line.Arg.DefaultValue, image.Mapping)
 		}
 
 		// Process RUN commands
 		if line.Run != nil && line.Run.Shell != nil && line.Run.Shell.Before != nil {
-			err := processRunLineWithConverter(newLine, line, stagePackages, mappings.Packages, opts.RunLineConverter)
+			contents := resolveImageContents(stageImages[line.Stage].Mapping.Image, stageImages[line.Stage].Tag, mappings.Contents)
+			err := processRunLineWithConverter(newLine, line, stagePackages, mappings.Packages, contents, downloads, tools, handlers, opts.RunLineConverter)
 			if err != nil {
 				return nil, err
 			}
 		}
 
+		// Map images referenced by COPY --from and RUN --mount=from
+		convertExternalReferences(ctx, newLine, graph, opts.withMappings(mappings))
+
 		// Add the converted line to the result
 		converted.Lines[i] = newLine
 	}
 
-	// Second pass: add USER root directives where needed
-	addUserRootDirectives(converted.Lines)
+	// Rewrite the paths that differ in the converted images
+	rewritePaths(converted.Lines, stageImages, mappings.Paths)
 
-	return converted, nil
-}
+	// Install the runtimes installed with version managers from packages
+	converted.Lines = replaceVersionManagers(converted.Lines, graph, stageImages, opts.withMappings(mappings))
 
-// detectStagesWithRunCommands identifies which stages contain RUN commands
-func detectStagesWithRunCommands(lines []*DockerfileLine) map[int]bool {
-	stagesWithRunCommands := make(map[int]bool)
+	// Replace the installer scripts piped into a shell
+	replaceInstallers(converted.Lines, graph, resolveInstallers(mappings.Installers))
 
-	for _, line := range lines {
-		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line.Raw)), DirectiveRun+" ") {
-			stagesWithRunCommands[line.Stage] = true
-		}
+	// Use the nonroot user of Chainguard images instead of creating app users if requested
+	var nonRootStages map[int]bool
+	if opts.NonRoot {
+		converted.Lines, nonRootStages = useNonRootUser(converted.Lines, graph)
 	}
 
-	return stagesWithRunCommands
+	// Second pass: make bash available to stages that rely on it
+	bashStages := addBashSupport(converted.Lines, graph)
+
+	// Third pass: add USER root directives where needed
+	rootStages := addUserRootDirectives(converted.Lines, graph, mergeMap(nonRootStages, bashStages))
+
+	// Fourth pass: switch back to the original user according to the policy
+	restoreUserDirectives(converted.Lines, graph, rootStages, opts.RestoreUser)
+
+	// Finally, split into build and runtime stages if requested
+	if opts.SplitRuntime {
+		converted.Lines = splitRuntime(ctx, converted.Lines, graph, stagePackages, opts.withMappings(mappings))
+	}
+
+	// Make ENTRYPOINT and CMD work with the entrypoint and shell of the converted images
+	checkEntrypoints(converted.Lines, mappings.Metadata)
+
+	logNotes(ctx, converted.Lines)
+
+	return converted, nil
 }
 
 // identifyArgsUsedAsBaseImages identifies ARGs that are used as base images
@@ -661,224 +775,111 @@ func copyFromDetails(from *FromDetails) *FromDetails {
 }
 
 // convertFromLine handles converting a FROM line
-func convertFromLine(from *FromDetails, stage int, stagesWithRunCommands map[int]bool, opts Options) string {
-	// First, always do the default Chainguard conversion
+func convertFromLine(ctx context.Context, from *FromDetails, stage int, stagesWithRunCommands map[int]bool, linkage map[int]linkNeed, opts Options) (string, convertedImage) {
 	// Determine if we need the -dev suffix
-	needsDevSuffix := stagesWithRunCommands[stage]
-
-	// Get the converted base without tag
-	base := from.Base
-	tag := from.Tag
-
-	// Handle the basename
-	baseFilename := filepath.Base(base)
-
-	// Get the appropriate Chainguard image name using mappings
-	targetImage := baseFilename
-	var convertedTag string
-
-	// Check for exact match first, in specific order
-	// For example, if the mapping is just node, it should match all of the following:
-	// FROM registry-1.docker.io/library/node
-	// FROM docker.io/node
-	// FROM docker.io/library/node
-	// FROM index.docker.io/node
-	// FROM index.docker.io/library/node
-	//
-	// If the mapping is someorg/somerepo, it should match all of the following:
-	// FROM registry-1.docker.io/someorg/somerepo
-	// FROM docker.io/someorg/somerepo
-	// FROM index.docker.io/someorg/somerepo
-	var mappedImage string
-
-	// First check for exact match with full image reference including tag
-	fullImageRef := base
-	if tag != "" {
-		fullImageRef += ":" + tag
-	}
-	if img, ok := opts.ExtraMappings.Images[fullImageRef]; ok {
-		mappedImage = img
-	} else if img, ok := opts.ExtraMappings.Images[base]; ok {
-		mappedImage = img
-	} else if img, ok := opts.ExtraMappings.Images[baseFilename]; ok {
-		mappedImage = img
-	} else {
-		// Generate all possible variants for the base image
-		baseVariants := generateDockerHubVariants(base)
-
-		// Check if any variant matches a key in the mappings
-		for _, variant := range baseVariants {
-			if img, ok := opts.ExtraMappings.Images[variant]; ok {
-				mappedImage = img
-				break
-			}
-		}
-
-		// If still no match, try to normalize the base and check against simple keys
-		if mappedImage == "" {
-			normalizedBase := normalizeImageName(base)
+	image := convertImageReference(ctx, from, stagesWithRunCommands[stage], linkage[stage], opts)
+	fromLine := DirectiveFrom + " " + image.Ref
+	if from.Alias != "" {
+		fromLine += " " + KeywordAs + " " + from.Alias
+	}
+	return fromLine, image
+}
 
-			// Check if the normalized base matches any key
-			if img, ok := opts.ExtraMappings.Images[normalizedBase]; ok {
-				mappedImage = img
-			} else if strings.HasPrefix(normalizedBase, "library/") {
-				// Try removing library/ prefix if it exists
-				simpleBase := strings.TrimPrefix(normalizedBase, "library/")
-				if img, ok := opts.ExtraMappings.Images[simpleBase]; ok {
-					mappedImage = img
-				}
-			}
-		}
+// convertedImage is the result of converting an image reference
+type convertedImage struct {
+	Ref     string       // Converted image reference
+	Tag     string       // Converted tag, before any custom conversion or pinning
+	Mapping ImageMapping // Image mapping that was used
+	Notes   []string     // Explanations of changes made (or needed) to the converted reference
+}
 
-		// If still no match, check for glob patterns with asterisks
-		if mappedImage == "" {
-			for pattern, img := range opts.ExtraMappings.Images {
-				if strings.HasSuffix(pattern, "*") {
-					prefix := strings.TrimSuffix(pattern, "*")
-					if strings.HasPrefix(baseFilename, prefix) {
-						mappedImage = img
-						break
-					}
-				}
-			}
-		}
+// convertImageReference converts an image reference (from a FROM line, an ARG used as base image,
+// or COPY --from) to the equivalent Chainguard image reference, using the image mappings, the tag
+// catalog and the custom FromLineConverter, and pins it to a digest if there is a DigestResolver.
+// Distroless images are mapped to an image providing the linkage needed by the stage.
+func convertImageReference(ctx context.Context, from *FromDetails, needsDevSuffix bool, need linkNeed, opts Options) convertedImage {
+	// First, always do the default Chainguard conversion
+	image := convertedImage{Mapping: resolveImage(from.Base, from.Tag, opts.ExtraMappings.Images)}
+	var note string
+	if image.Mapping, note = upgradeLinkage(image.Mapping, need); note != "" {
+		image.Notes = append(image.Notes, note)
 	}
 
-	// Process the mapped image if found
-	if mappedImage != "" {
-		// Check if the mapped image includes a tag
-		if parts := strings.Split(mappedImage, ":"); len(parts) > 1 {
-			targetImage = parts[0]
-			convertedTag = parts[1]
-		} else {
-			targetImage = mappedImage
+	// If the tag is not specified in the mapping, calculate it using the existing logic
+	convertedTag := image.Mapping.Tag
+	if convertedTag == "" {
+		tag := from.Tag
+		if image.Mapping.Version != "" {
+			tag = image.Mapping.Version
 		}
+		convertedTag = calculateConvertedTag(image.Mapping.Image, tag, needsDevSuffix, opts.ExtraMappings.Tags, opts.TagStrategy)
 	}
 
-	// If targetTag is not specified in mapping, calculate it using the existing logic
-	if convertedTag == "" {
-		convertedTag = calculateConvertedTag(targetImage, tag, from.TagDynamic, needsDevSuffix)
+	// Check that the tag exists, or use the nearest one that does
+	convertedTag, note = opts.TagCatalog.checkTag(image.Mapping.Image, convertedTag)
+	if note != "" {
+		image.Notes = append(image.Notes, note)
 	}
+	image.Tag = convertedTag
 
 	// Build the image reference
-	chainguardImageRef := buildImageReference(targetImage, convertedTag, opts)
+	chainguardImageRef := buildImageReference(image.Mapping.Image, convertedTag, opts)
 
 	// Now, if a custom converter is provided, let it process the result
 	if opts.FromLineConverter != nil {
-		customImageRef, err := opts.FromLineConverter(from, chainguardImageRef, stagesWithRunCommands[stage])
+		customImageRef, err := opts.FromLineConverter(from, chainguardImageRef, needsDevSuffix)
 		if err != nil {
-			// If an error occurs, still return a valid FROM line using the original image
-			fromLine := DirectiveFrom + " " + from.Orig
-			if from.Alias != "" {
-				fromLine += " " + KeywordAs + " " + from.Alias
-			}
-			return fromLine
+			// If an error occurs, still return a valid reference using the original image
+			image.Ref = from.Orig
+			return image
 		}
+		image.Ref = customImageRef
+	} else {
+		// If no custom converter, use the Chainguard converted reference
+		image.Ref = chainguardImageRef
+	}
 
-		// Create the converted FROM line with the custom image
-		fromLine := DirectiveFrom + " " + customImageRef
-		if from.Alias != "" {
-			fromLine += " " + KeywordAs + " " + from.Alias
+	// Pin the converted reference to a digest
+	if opts.DigestResolver != nil {
+		pinned, err := pinImageReference(ctx, image.Ref, opts.DigestResolver)
+		if err != nil {
+			image.Notes = append(image.Notes, fmt.Sprintf("could not pin %s to a digest: %v", image.Ref, err))
 		}
-		return fromLine
+		image.Ref = pinned
 	}
+	return image
+}
 
-	// If no custom converter, use the Chainguard converted reference
-	fromLine := DirectiveFrom + " " + chainguardImageRef
-	if from.Alias != "" {
-		fromLine += " " + KeywordAs + " " + from.Alias
+// logImageMapping logs which image mapping was used to convert an image reference
+func logImageMapping(ctx context.Context, ref string, mapping ImageMapping) {
+	if mapping.Rule == MappingRuleNone {
+		clog.FromContext(ctx).Debug("no image mapping matched", "image", ref, "target", mapping.Image)
+		return
 	}
-
-	return fromLine
+	clog.FromContext(ctx).Debug("image mapping matched", "image", ref, "target", mapping.Image, "rule", mapping.Rule, "key", mapping.Key)
 }
 
 // convertArgLine handles converting an ARG line used as base image
-func convertArgLine(arg *ArgDetails, lines []*DockerfileLine, stagesWithRunCommands map[int]bool, opts Options) (string, *ArgDetails) {
-	// Create a FromDetails structure from the ARG default value
-	base, tag := parseImageReference(arg.DefaultValue)
-
-	// Create a FromDetails to represent this ARG value as a FROM line
-	fromDetails := &FromDetails{
-		Base: base,
-		Tag:  tag,
-		Orig: arg.DefaultValue,
-	}
+func convertArgLine(ctx context.Context, arg *ArgDetails, lines []*DockerfileLine, stagesWithRunCommands map[int]bool, opts Options) (string, *ArgDetails, convertedImage) {
+	// Convert the ARG value like the image of a FROM line
+	fromDetails := parseFromDetails(arg.DefaultValue)
 
 	// Determine if we need the -dev suffix
 	needsDevSuffix := determineIfArgNeedsDevSuffix(arg.Name, lines, stagesWithRunCommands)
 
-	// First perform the default Chainguard conversion
-	// Calculate default image reference using common approach
-	baseFilename := filepath.Base(base)
-
-	// Get the appropriate Chainguard image name using mappings
-	targetImage := baseFilename
-	var convertedTag string
-
-	// Check for exact match first
-	if mappedImage, ok := opts.ExtraMappings.Images[baseFilename]; ok {
-		// Check if the mapped image includes a tag
-		if parts := strings.Split(mappedImage, ":"); len(parts) > 1 {
-			targetImage = parts[0]
-			convertedTag = parts[1]
-		} else {
-			targetImage = mappedImage
-		}
-	} else {
-		// No exact match, check for glob patterns with asterisks
-		for pattern, mappedImage := range opts.ExtraMappings.Images {
-			if strings.HasSuffix(pattern, "*") {
-				prefix := strings.TrimSuffix(pattern, "*")
-				if strings.HasPrefix(baseFilename, prefix) {
-					// Found a match with a glob pattern
-					if parts := strings.Split(mappedImage, ":"); len(parts) > 1 {
-						targetImage = parts[0]
-						convertedTag = parts[1]
-					} else {
-						targetImage = mappedImage
-					}
-					break
-				}
-			}
-		}
-	}
-
-	// If targetTag is not specified in mapping, calculate it using the existing logic
-	if convertedTag == "" {
-		convertedTag = calculateConvertedTag(targetImage, tag, false, needsDevSuffix)
-	}
-
-	// Build the image reference
-	chainguardImageRef := buildImageReference(targetImage, convertedTag, opts)
-
-	// Get the converted image reference
-	var finalImageRef string
-
-	// If a custom FROM line converter is provided, use it
-	if opts.FromLineConverter != nil {
-		customImageRef, err := opts.FromLineConverter(fromDetails, chainguardImageRef, needsDevSuffix)
-		if err != nil {
-			// On error, use the original value
-			finalImageRef = arg.DefaultValue
-		} else {
-			finalImageRef = customImageRef
-		}
-	} else {
-		// Use the default converter result
-		finalImageRef = chainguardImageRef
-	}
+	image := convertImageReference(ctx, fromDetails, needsDevSuffix, linkNeed{}, opts)
 
 	// Create the converted ARG line
-	argLine := DirectiveArg + " " + arg.Name + "=" + finalImageRef
+	argLine := DirectiveArg + " " + arg.Name + "=" + image.Ref
 
 	// Create the Arg details
 	argDetails := &ArgDetails{
 		Name:         arg.Name,
-		DefaultValue: finalImageRef,
+		DefaultValue: image.Ref,
 		UsedAsBase:   true,
 	}
 
-	return argLine, argDetails
+	return argLine, argDetails, image
 }
 
 // determineIfArgNeedsDevSuffix determines if an ARG used as base needs a -dev suffix
@@ -893,30 +894,21 @@ func determineIfArgNeedsDevSuffix(argName string, lines []*DockerfileLine, stage
 	return false
 }
 
-// calculateConvertedTag calculates the appropriate tag based on the base image and whether -dev is needed
-func calculateConvertedTag(baseFilename string, tag string, isDynamicTag bool, needsDevSuffix bool) string {
+// calculateConvertedTag calculates the appropriate tag based on the base image, the tag rules, the tag strategy
+// and whether -dev is needed
+func calculateConvertedTag(baseFilename string, tag string, needsDevSuffix bool, tags map[string][]TagRule, strategy TagStrategy) string {
 	var convertedTag string
 
-	// Special case for chainguard-base - always use latest
+	// Special case for chainguard-base - it is only tagged latest, whatever the tag strategy
 	if baseFilename == DefaultChainguardBase {
 		return "latest" // Always use latest tag for chainguard-base, no -dev suffix ever
 	}
 
-	// First process the tag normally (including semantic version truncation)
-	switch {
-	case tag == "":
+	// First process the tag with the tag rules (including semantic version truncation)
+	if tag == "" {
 		convertedTag = "latest"
-	case strings.Contains(tag, "$"):
-		// For dynamic tags, preserve the original tag
-		convertedTag = tag
-	default:
-		// Convert the tag normally for static tags
-		convertedTag = convertImageTag(tag, isDynamicTag)
-	}
-
-	// Special case for JDK/JRE - prepend "openjdk-" to the tag unless it's "latest" or "latest-dev"
-	if (baseFilename == "jdk" || baseFilename == "jre") && convertedTag != "latest" && convertedTag != "latest-dev" {
-		convertedTag = "openjdk-" + convertedTag
+	} else {
+		convertedTag = convertTag(baseFilename, tag, tags, strategy)
 	}
 
 	// Add -dev suffix if needed
@@ -956,7 +948,7 @@ func buildImageReference(baseFilename string, tag string, opts Options) string {
 }
 
 // processRunLineWithConverter handles the conversion of RUN lines but supports a RunLineConverter.
-func processRunLineWithConverter(newLine *DockerfileLine, line *DockerfileLine, stagePackages map[int][]string, packageMap PackageMap, runLineConverter RunLineConverter) error {
+func processRunLineWithConverter(newLine *DockerfileLine, line *DockerfileLine, stagePackages map[int][]string, packageMap PackageMap, contents imageContents, downloads []download, tools map[string]ToolMap, handlers []CommandHandler, runLineConverter RunLineConverter) error {
 	beforeShell := line.Run.Shell.Before
 
 	// Initialize RunDetails with Before shell
@@ -967,8 +959,9 @@ func processRunLineWithConverter(newLine *DockerfileLine, line *DockerfileLine,
 	}
 
 	// First check for package manager commands
-	modifiedPMCommands, distro, manager, packages, mappedPackages, afterShell :=
-		convertPackageManagerCommands(beforeShell, packageMap)
+	modifiedPMCommands, distro, manager, packages, mappedPackages, afterShell, notes :=
+		convertPackageManagerCommands(beforeShell, packageMap, contents)
+	newLine.Notes = append(newLine.Notes, notes...)
 	newLine.Run.Distro = distro
 	newLine.Run.Manager = manager
 	newLine.Run.Packages = packages
@@ -981,33 +974,33 @@ func processRunLineWithConverter(newLine *DockerfileLine, line *DockerfileLine,
 		stagePackages[line.Stage] = append(stagePackages[line.Stage], mappedPackages...)
 	}
 
-	modifiedBusyboxCommands := false
-	modifiedBusyboxCommands, afterShell = convertBusyboxCommands(afterShell, stagePackages[line.Stage])
+	// Then for release binaries downloaded with curl or wget that are packaged
+	modifiedDownloads, downloadedPackages, afterShell, notes := convertDownloads(afterShell, downloads)
+	newLine.Notes = append(newLine.Notes, notes...)
+	stagePackages[line.Stage] = append(stagePackages[line.Stage], downloadedPackages...)
+
+	// Then for tools installed with language package managers, if requested (tools is not nil)
+	modifiedTools := false
+	if tools != nil {
+		var toolPackages []string
+		modifiedTools, toolPackages, afterShell, notes = convertToolInstalls(afterShell, tools)
+		newLine.Notes = append(newLine.Notes, notes...)
+		stagePackages[line.Stage] = append(stagePackages[line.Stage], toolPackages...)
+	}
+
+	modifiedBusyboxCommands, addedPackages, afterShell := convertBusyboxCommands(afterShell, stagePackages[line.Stage], handlers)
+	if len(addedPackages) > 0 {
+		stagePackages[line.Stage] = append(stagePackages[line.Stage], addedPackages...)
+		newLine.Notes = append(newLine.Notes, fmt.Sprintf("installed %s: command options have no busybox equivalent", strings.Join(addedPackages, ", ")))
+	}
 
 	// Check if we modified anything (related to package managers or useradd/groupadd)
-	modifiedAnything := modifiedPMCommands || modifiedBusyboxCommands
+	modifiedAnything := modifiedPMCommands || modifiedDownloads || modifiedTools || modifiedBusyboxCommands
 
 	// If we modified the shell command, set After and Converted
 	if modifiedAnything {
 		newLine.Run.Shell.After = afterShell
-
-		// Extract the original RUN directive from the raw line to preserve case
-		rawLine := line.Raw
-		upperRawLine := strings.ToUpper(rawLine)
-
-		// Find the position of the case-insensitive "RUN " directive
-		runPrefix := DirectiveRun + " "
-		runIndex := strings.Index(upperRawLine, runPrefix)
-
-		var defaultConverted string
-		if runIndex != -1 {
-			// Get the original case of the RUN directive
-			originalRunDirective := rawLine[runIndex : runIndex+len(runPrefix)]
-			defaultConverted = originalRunDirective + afterShell.String()
-		} else {
-			// Fallback if we can't find the directive (shouldn't happen)
-			defaultConverted = DirectiveRun + " " + afterShell.String()
-		}
+		defaultConverted := runInstruction(line.Raw, afterShell)
 
 		if runLineConverter != nil {
 			custom, err := runLineConverter(newLine.Run, defaultConverted, line.Stage)
@@ -1022,32 +1015,58 @@ func processRunLineWithConverter(newLine *DockerfileLine, line *DockerfileLine,
 	return nil
 }
 
-// addUserRootDirectives adds USER root directives where needed
-func addUserRootDirectives(lines []*DockerfileLine) {
+// runInstruction returns the RUN instruction of a raw line with a converted shell command
+func runInstruction(rawLine string, shell *ShellCommand) string {
+	upperRawLine := strings.ToUpper(rawLine)
+
+	// Find the position of the case-insensitive "RUN " directive
+	runPrefix := DirectiveRun + " "
+	runIndex := strings.Index(upperRawLine, runPrefix)
+	if runIndex == -1 {
+		// Fallback if we can't find the directive (shouldn't happen)
+		return DirectiveRun + " " + shell.String()
+	}
+
+	// Keep the original case of the RUN directive
+	return rawLine[runIndex:runIndex+len(runPrefix)] + shell.String()
+}
+
+// addUserRootDirectives adds USER root directives where needed and returns the stages it added them to. Stages in extraStages
+// need root even if none of their RUN lines were converted (e.g. packages were added to them). Stages built on another
+// stage inherit its user, so converted RUN lines in them need USER root in the stage at the root of their FROM-parent chain.
+func addUserRootDirectives(lines []*DockerfileLine, graph *StageGraph, extraStages map[int]bool) map[int]bool {
 	// First determine which stages have converted RUN lines
 	stagesWithConvertedRuns := make(map[int]bool)
+	for stage := range extraStages {
+		stagesWithConvertedRuns[stage] = true
+	}
 	// Also keep track of stages that already have USER root directives
 	stagesWithUserRoot := make(map[int]bool)
+	addedUserRoot := make(map[int]bool)
+	// And of stages setting a user, which their descendants inherit instead of the base image user
+	stagesWithUser := make(map[int]bool)
 
 	// First pass - identify stages with converted RUN lines and existing USER root directives
 	for _, line := range lines {
 		// Check if this is a converted RUN line
-		if line.Run != nil && line.Converted != "" {
+		if convertedRun(line) {
 			stagesWithConvertedRuns[line.Stage] = true
+			if root, ok := inheritedUserStage(graph, line.Stage, stagesWithUser); ok {
+				stagesWithConvertedRuns[root] = true
+			}
 		}
-
-		// Check if this line is a USER directive with root
-		raw := line.Raw
-		converted := line.Converted
-
-		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(raw)), DirectiveUser+" ") &&
-			strings.Contains(strings.ToLower(raw), DefaultUser) {
-			stagesWithUserRoot[line.Stage] = true
+		if _, ok := userDirectiveValue(line.Raw); ok {
+			stagesWithUser[line.Stage] = true
 		}
 
-		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(converted)), DirectiveUser+" ") &&
-			strings.Contains(strings.ToLower(converted), DefaultUser) {
-			stagesWithUserRoot[line.Stage] = true
+		// Check if this line is a USER directive with root (but not nonroot)
+		for _, text := range []string{line.Raw, line.Converted} {
+			instruction, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+			if value, ok := userDirectiveValue(instruction); ok {
+				if user, _, _ := strings.Cut(value, ":"); strings.EqualFold(user, DefaultUser) {
+					stagesWithUserRoot[line.Stage] = true
+				}
+			}
 		}
 	}
 
@@ -1058,77 +1077,206 @@ func addUserRootDirectives(lines []*DockerfileLine) {
 			if line.From != nil && stagesWithConvertedRuns[line.Stage] {
 				// If the FROM line was converted and there's no USER root directive in this stage already
 				if line.Converted != "" && !stagesWithUserRoot[line.Stage] {
-					// Add a USER root directive after this FROM line
-					line.Converted += "\n" + DirectiveUser + " " + DefaultUser
+					// Add a USER root directive directly after the FROM instruction,
+					// ahead of anything else already inserted after it
+					fromInstruction, rest, _ := strings.Cut(line.Converted, "\n")
+					line.Converted = fromInstruction + "\n" + DirectiveUser + " " + DefaultUser
+					if rest != "" {
+						line.Converted += "\n" + rest
+					}
 					// Mark this stage as having a USER root directive
 					stagesWithUserRoot[line.Stage] = true
+					addedUserRoot[line.Stage] = true
 				}
 			}
 		}
 	}
+
+	return addedUserRoot
 }
 
-// shouldConvertFromLine determines if a FROM line should be converted
-func shouldConvertFromLine(from *FromDetails) bool {
-	// Skip conversion for scratch, parent stages, or dynamic bases
-	if from.Base == "scratch" || from.Parent > 0 || from.BaseDynamic {
-		return false
+// dropLines removes lines left with nothing to do, moving their comments and notes to the next
+// line (or their notes to the last line)
+func dropLines(lines []*DockerfileLine, drop map[*DockerfileLine]bool) []*DockerfileLine {
+	if len(drop) == 0 {
+		return lines
 	}
-	return true
+	result := make([]*DockerfileLine, 0, len(lines))
+	var extra string
+	var notes []string
+	for _, line := range lines {
+		if drop[line] {
+			extra, notes = extra+line.Extra, append(notes, line.Notes...)
+			continue
+		}
+		if extra != "" || len(notes) > 0 {
+			line.Extra = extra + line.Extra
+			line.Notes = append(notes, line.Notes...)
+			extra, notes = "", nil
+		}
+		result = append(result, line)
+	}
+	if len(notes) > 0 && len(result) > 0 {
+		last := result[len(result)-1]
+		last.Notes = append(last.Notes, notes...)
+	}
+	return result
 }
 
-// convertImageTag returns the converted image tag
-func convertImageTag(tag string, _ bool) string {
-	if tag == "" {
-		return DefaultImageTag
+// convertedRun checks if the commands of a RUN line were converted, as opposed to only the
+// instruction around them (e.g. an inserted SHELL directive or a mapped --mount image)
+func convertedRun(line *DockerfileLine) bool {
+	return line.Run != nil && line.Run.Shell != nil && line.Run.Shell.After != nil
+}
+
+// inheritedUserStage returns the stage at the root of the FROM-parent chain of a stage, unless
+// a stage along the chain sets its own user
+func inheritedUserStage(graph *StageGraph, number int, stagesWithUser map[int]bool) (int, bool) {
+	for stage := graph.Stage(number); stage != nil && stage.Parent > 0; stage = graph.Stage(stage.Parent) {
+		if stagesWithUser[stage.Parent] {
+			return 0, false
+		}
+	}
+	return graph.Root(number), true
+}
+
+// userDirectiveValue returns the user set by a USER directive
+func userDirectiveValue(raw string) (string, bool) {
+	fields := strings.Fields(raw)
+	if len(fields) != 2 || strings.ToUpper(fields[0]) != DirectiveUser {
+		return "", false
 	}
+	return fields[1], true
+}
 
-	// Remove anything after and including the first hyphen
-	if hyphenIndex := strings.Index(tag, "-"); hyphenIndex != -1 {
-		tag = tag[:hyphenIndex]
+// insertAfterInstruction inserts a directive directly after the first line of a (possibly converted) line
+func insertAfterInstruction(line *DockerfileLine, directive string) {
+	converted := line.Converted
+	if converted == "" {
+		converted = strings.TrimSpace(line.Raw)
 	}
+	instruction, rest, _ := strings.Cut(converted, "\n")
+	line.Converted = instruction + "\n" + directive
+	if rest != "" {
+		line.Converted += "\n" + rest
+	}
+}
 
-	// If tag has 'v' prefix for semver, remove it
-	if len(tag) > 0 && tag[0] == 'v' && (len(tag) > 1 && (tag[1] >= '0' && tag[1] <= '9')) {
-		tag = tag[1:]
+// appendDirective appends a directive after a (possibly converted) line
+func appendDirective(line *DockerfileLine, directive string) {
+	if line.Converted == "" {
+		line.Converted = strings.TrimSpace(line.Raw)
 	}
+	line.Converted += "\n" + directive
+}
 
-	// Check if this is a semver tag (e.g. 1.2.3)
-	semverParts := strings.Split(tag, ".")
-	isSemver := false
+// restoreUserDirectives switches stages back from the USER root added by addUserRootDirectives
+// to the user they would otherwise run as: the last USER of the stage, the user inherited from
+// the parent stage, or nonroot (the default of Chainguard images) for converted bases.
+// The USER directive is added after the last converted RUN line of the stage. Stages built on
+// a stage that switched back get their own USER root when they have converted RUN lines.
+func restoreUserDirectives(lines []*DockerfileLine, graph *StageGraph, rootStages map[int]bool, policy UserPolicy) {
+	if policy == "" || policy == UserPolicyNone {
+		return
+	}
 
-	// Consider tags that are just a number (like "9" or "18") as valid semver-like tags
-	if len(semverParts) == 1 {
-		_, err := strconv.Atoi(semverParts[0])
-		if err == nil {
-			isSemver = true
+	// Group the lines by stage
+	stageLines := make(map[int][]*DockerfileLine)
+	for _, line := range lines {
+		if line.Stage > 0 {
+			stageLines[line.Stage] = append(stageLines[line.Stage], line)
 		}
-	} else if len(semverParts) >= 2 {
-		// Check if at least the first two parts are numeric
-		major, majorErr := strconv.Atoi(semverParts[0])
-		minor, minorErr := strconv.Atoi(semverParts[1])
-		if majorErr == nil && minorErr == nil && major >= 0 && minor >= 0 {
-			isSemver = true
-			// Keep only major.minor for semver tags
-			if len(semverParts) > 2 {
-				tag = fmt.Sprintf("%d.%d", major, minor)
+	}
+
+	// The user each stage ends with, and the user it would end with without USER root
+	effective := make(map[int]string)
+	original := make(map[int]string)
+
+	for _, s := range graph.Stages {
+		stage := s.Number
+		stageLines := stageLines[stage]
+		if len(stageLines) == 0 || stageLines[0].From == nil || !graph.Builds(stage) {
+			continue
+		}
+		fromLine := stageLines[0]
+
+		var orig, eff string
+		switch {
+		case s.Parent > 0:
+			orig, eff = original[s.Parent], effective[s.Parent]
+		case fromLine.Converted != "":
+			orig, eff = DefaultNonRootUser, DefaultNonRootUser
+			if rootStages[stage] {
+				eff = DefaultUser
+			}
+		}
+		restore := policy == UserPolicyAll || stage == graph.BuildStage()
+
+		var lastConverted *DockerfileLine
+		var hasUser bool
+		for _, line := range stageLines[1:] {
+			if user, ok := userDirectiveValue(lineText(line)); ok {
+				orig, eff = user, user
+				hasUser = true
+				continue
+			}
+			if !convertedRun(line) {
+				continue
 			}
+			// Converted RUN lines inheriting a non-root user from their parent need root again
+			if s.Parent > 0 && !hasUser && eff != "" && eff != DefaultUser {
+				insertAfterInstruction(fromLine, DirectiveUser+" "+DefaultUser)
+				fromLine.Notes = append(fromLine.Notes, fmt.Sprintf("added %s %s, the parent stage switched back to %s", DirectiveUser, DefaultUser, eff))
+				eff = DefaultUser
+			}
+			lastConverted = line
+		}
+
+		// Stages only running as root for the sake of their descendants stay root
+		if lastConverted == nil && len(graph.Descendants(stage)) > 0 {
+			restore = false
+		}
+		if restore && eff == DefaultUser && orig != "" && orig != DefaultUser {
+			anchor := lastConverted
+			if anchor == nil {
+				anchor = fromLine
+			}
+			appendDirective(anchor, DirectiveUser+" "+orig)
+			anchor.Notes = append(anchor.Notes, fmt.Sprintf("switched back to %s %s after running as %s", DirectiveUser, orig, DefaultUser))
+			eff = orig
 		}
+
+		original[stage] = orig
+		effective[stage] = eff
 	}
+}
 
-	// If not a semver and not latest, use latest
-	if !isSemver && tag != "latest" {
-		return "latest"
+// logNotes logs the notes recorded on converted lines
+func logNotes(ctx context.Context, lines []*DockerfileLine) {
+	log := clog.FromContext(ctx)
+	for _, line := range lines {
+		for _, note := range line.Notes {
+			instruction, _, _ := strings.Cut(strings.TrimSpace(line.Raw), "\n")
+			log.Info(note, "stage", line.Stage, "instruction", instruction)
+		}
 	}
+}
 
-	return tag
+// shouldConvertFromLine determines if a FROM line should be converted
+func shouldConvertFromLine(from *FromDetails) bool {
+	// Skip conversion for scratch, parent stages, or dynamic bases
+	if from.Base == "scratch" || from.Parent > 0 || from.BaseDynamic {
+		return false
+	}
+	return true
 }
 
 // convertPackageManagerCommands converts package manager commands in a shell command
-// to the Alpine equivalent (apk add)
-func convertPackageManagerCommands(shell *ShellCommand, packageMap PackageMap) (bool, Distro, Manager, []string, []string, *ShellCommand) {
+// to the Alpine equivalent (apk add), dropping the packages already shipped by the image of the stage.
+// It also returns notes about the dropped packages and the packages conflicting with the image.
+func convertPackageManagerCommands(shell *ShellCommand, packageMap PackageMap, contents imageContents) (bool, Distro, Manager, []string, []string, *ShellCommand, []string) {
 	if shell == nil {
-		return false, "", "", nil, nil, nil
+		return false, "", "", nil, nil, nil, nil
 	}
 
 	// Determine which distro/package manager we're going to focus on
@@ -1191,7 +1339,7 @@ func convertPackageManagerCommands(shell *ShellCommand, packageMap PackageMap) (
 
 	// If we don't have any package manager commands, return the original shell
 	if !hasPackageManager {
-		return false, distro, firstPM, nil, nil, shell
+		return false, distro, firstPM, nil, nil, shell, nil
 	}
 
 	// Sort and deduplicate packages
@@ -1214,6 +1362,9 @@ func convertPackageManagerCommands(shell *ShellCommand, packageMap PackageMap) (
 	}
 	slices.Sort(packagesToInstall)
 
+	// Skip the packages the image already has
+	packagesToInstall, notes := contents.dedupe(packagesToInstall)
+
 	// If we only have package manager commands and no non-PM commands,
 	// and we found packages to install, convert it to just an apk add command
 	if !hasNonPackageManagerCommands && len(packagesToInstall) > 0 {
@@ -1225,7 +1376,7 @@ func convertPackageManagerCommands(shell *ShellCommand, packageMap PackageMap) (
 					Args:    append([]string{SubcommandAdd, ApkNoCacheFlag}, packagesToInstall...),
 				},
 			},
-		}
+		}, notes
 	}
 
 	// If we only have package manager commands but no packages to install,
@@ -1237,7 +1388,7 @@ func convertPackageManagerCommands(shell *ShellCommand, packageMap PackageMap) (
 					Command: "true",
 				},
 			},
-		}
+		}, notes
 	}
 
 	// Create a new shell command with parts
@@ -1305,7 +1456,7 @@ func convertPackageManagerCommands(shell *ShellCommand, packageMap PackageMap) (
 		})
 	}
 
-	return true, distro, firstPM, packagesDetected, packagesToInstall, &ShellCommand{Parts: newParts}
+	return true, distro, firstPM, packagesDetected, packagesToInstall, &ShellCommand{Parts: newParts}, notes
 }
 
 // Helper function to clone a shell part
@@ -1325,79 +1476,286 @@ func cloneShellPart(part *ShellPart) *ShellPart {
 // CommandConverter defines a function type for converting shell commands
 type CommandConverter func(*ShellPart) *ShellPart
 
-// CommandHandler represents a handler for a specific command conversion
+// CommandHandler represents a handler for a specific command conversion.
+// Custom handlers can be passed to Convert with Options.CommandHandlers, where they replace
+// any built-in handlers for the same command. They are applied to every RUN line.
+//
+// Example usage:
+//
+//	pkgInstall := dfc.CommandHandler{
+//	    Command: "pkg-install",
+//	    Converter: func(part *dfc.ShellPart) *dfc.ShellPart {
+//	        return &dfc.ShellPart{
+//	            Command:   "apk",
+//	            Args:      append([]string{"add", "--no-cache"}, part.Args...),
+//	            Delimiter: part.Delimiter,
+//	        }
+//	    },
+//	    SkipIfInstalled: []string{"pkg-tools"},
+//	}
+//
+//	dockerFile.Convert(ctx, dfc.Options{
+//	    CommandHandlers: []dfc.CommandHandler{pkgInstall},
+//	})
 type CommandHandler struct {
 	Command             string
 	Converter           CommandConverter
-	SkipIfShadowPresent bool // If true, only convert when shadow is NOT installed
+	SkipIfShadowPresent bool                  // If true, only convert when shadow is NOT installed (same as SkipIfInstalled: shadow)
+	OnlyIfInstalled     []string              // Only convert when all of these packages are installed in the stage
+	SkipIfInstalled     []string              // Only convert when none of these packages are installed in the stage
+	Package             string                // Package providing the GNU version of the command; when installed, the command is left as is
+	NeedsPackage        func(*ShellPart) bool // Reports commands that cannot be converted, for which Package is installed instead
 }
 
-// convertBusyboxCommands converts useradd and groupadd commands to adduser and addgroup and modifies the tar command syntax
-func convertBusyboxCommands(shell *ShellCommand, stagePackages []string) (bool, *ShellCommand) {
-	if shell == nil || len(shell.Parts) == 0 {
-		return false, shell
+// appliesTo checks if the handler conditions are met for the packages installed in a stage
+func (h CommandHandler) appliesTo(stagePackages []string) bool {
+	if h.SkipIfShadowPresent && slices.Contains(stagePackages, PackageShadow) {
+		return false
+	}
+	for _, pkg := range h.OnlyIfInstalled {
+		if !slices.Contains(stagePackages, pkg) {
+			return false
+		}
+	}
+	for _, pkg := range h.SkipIfInstalled {
+		if slices.Contains(stagePackages, pkg) {
+			return false
+		}
 	}
+	return true
+}
 
-	// Define command handlers
-	commandHandlers := []CommandHandler{
+// commandHandlers returns the handlers used to convert commands to their busybox equivalents,
+// with the extra handlers overriding the built-in ones for the same command
+func commandHandlers(extra []CommandHandler) []CommandHandler {
+	handlers := []CommandHandler{
+		{
+			Command:      CommandUserAdd,
+			Converter:    ConvertUserAddToAddUser,
+			Package:      PackageShadow,
+			NeedsPackage: userAddNeedsShadow,
+		},
 		{
-			Command:             CommandUserAdd,
-			Converter:           ConvertUserAddToAddUser,
-			SkipIfShadowPresent: true,
+			Command:         CommandGroupAdd,
+			Converter:       ConvertGroupAddToAddGroup,
+			SkipIfInstalled: []string{PackageShadow},
 		},
 		{
-			Command:             CommandGroupAdd,
-			Converter:           ConvertGroupAddToAddGroup,
-			SkipIfShadowPresent: true,
+			Command:      CommandUserMod,
+			Converter:    ConvertUserModToBusybox,
+			Package:      PackageShadow,
+			NeedsPackage: userModNeedsShadow,
+		},
+		{
+			Command:      CommandGPasswd,
+			Converter:    ConvertGPasswdToBusybox,
+			Package:      PackageShadow,
+			NeedsPackage: gpasswdNeedsShadow,
 		},
 		{
 			Command:   CommandGNUTar,
 			Converter: ConvertGNUTarToBusyboxTar,
 		},
 	}
+	handlers = append(handlers, gnuCommandHandlers()...)
+
+	if len(extra) == 0 {
+		return handlers
+	}
+
+	overridden := make(map[string]bool)
+	for _, handler := range extra {
+		overridden[handler.Command] = true
+	}
+	handlers = slices.DeleteFunc(handlers, func(handler CommandHandler) bool {
+		return overridden[handler.Command]
+	})
+	return append(handlers, extra...)
+}
+
+// validateCommandHandlers checks that custom command handlers can be used
+func validateCommandHandlers(handlers []CommandHandler) error {
+	for i, handler := range handlers {
+		if handler.Command == "" {
+			return fmt.Errorf("command handler %d: missing command", i)
+		}
+		if handler.Converter == nil && handler.NeedsPackage == nil {
+			return fmt.Errorf("command handler %d (%s): missing converter", i, handler.Command)
+		}
+		if handler.NeedsPackage != nil && handler.Package == "" {
+			return fmt.Errorf("command handler %d (%s): package required with NeedsPackage", i, handler.Command)
+		}
+	}
+	return nil
+}
+
+// splitPipeline splits a shell part into the commands of its pipeline
+func splitPipeline(part *ShellPart) []*ShellPart {
+	if !slices.Contains(part.Args, "|") {
+		return []*ShellPart{part}
+	}
+
+	current := &ShellPart{ExtraPre: part.ExtraPre, Command: part.Command}
+	commands := []*ShellPart{current}
+	for i := 0; i < len(part.Args); i++ {
+		if part.Args[i] == "|" && i+1 < len(part.Args) {
+			current = &ShellPart{Command: part.Args[i+1]}
+			commands = append(commands, current)
+			i++
+			continue
+		}
+		current.Args = append(current.Args, part.Args[i])
+	}
+	return commands
+}
+
+// splitChainedPart splits a shell part whose arguments contain && into separate parts
+func splitChainedPart(part *ShellPart) []*ShellPart {
+	if !slices.Contains(part.Args, "&&") {
+		return []*ShellPart{part}
+	}
+
+	current := &ShellPart{ExtraPre: part.ExtraPre, Command: part.Command}
+	parts := []*ShellPart{current}
+	for i := 0; i < len(part.Args); i++ {
+		if part.Args[i] == "&&" && i+1 < len(part.Args) {
+			current.Delimiter = "&&"
+			current = &ShellPart{Command: part.Args[i+1]}
+			parts = append(parts, current)
+			i++
+			continue
+		}
+		current.Args = append(current.Args, part.Args[i])
+	}
+	current.Delimiter = part.Delimiter
+	return parts
+}
+
+// joinPipeline joins the commands of a pipeline back into a single shell part
+func joinPipeline(commands []*ShellPart, delimiter string) *ShellPart {
+	result := &ShellPart{
+		ExtraPre:  commands[0].ExtraPre,
+		Command:   commands[0].Command,
+		Delimiter: delimiter,
+	}
+	result.Args = append(result.Args, commands[0].Args...)
+	for _, command := range commands[1:] {
+		result.Args = append(result.Args, "|", command.Command)
+		result.Args = append(result.Args, command.Args...)
+	}
+	return result
+}
+
+// convertBusyboxCommands converts commands to their busybox equivalents, such as useradd and groupadd
+// to adduser and addgroup, and GNU options to busybox options. Commands that cannot be converted
+// get the package providing the GNU version installed instead, which is also returned.
+func convertBusyboxCommands(shell *ShellCommand, stagePackages []string, handlers []CommandHandler) (bool, []string, *ShellCommand) {
+	if shell == nil || len(shell.Parts) == 0 {
+		return false, nil, shell
+	}
 
 	// Create new shell command to hold the converted parts
-	convertedParts := make([]*ShellPart, len(shell.Parts))
+	convertedParts := make([]*ShellPart, 0, len(shell.Parts))
 	modified := false
-
-	// Check if shadow is installed
-	hasShadow := slices.Contains(stagePackages, PackageShadow)
+	var packagesToAdd []string
+	firstNeedingPackage := -1
 
 	// Process each shell part
-	for i, part := range shell.Parts {
-		converted := false
+	for _, part := range shell.Parts {
+		commands := splitPipeline(part)
+		partModified := false
+
+		for j, command := range commands {
+			// Try each handler in the registry
+			for _, handler := range handlers {
+				// Check if this command matches
+				if command.Command != handler.Command {
+					continue
+				}
 
-		// Try each handler in the registry
-		for _, handler := range commandHandlers {
-			// Skip if this handler requires shadow checking and shadow is installed
-			if handler.SkipIfShadowPresent && hasShadow {
-				continue
-			}
+				// Skip if the packages installed in the stage rule this handler out
+				if !handler.appliesTo(stagePackages) {
+					continue
+				}
+
+				// The GNU version of the command is installed, nothing to convert
+				if handler.Package != "" && (slices.Contains(stagePackages, handler.Package) || slices.Contains(packagesToAdd, handler.Package)) {
+					break
+				}
+
+				// The command cannot be converted, install the package providing it instead
+				if handler.NeedsPackage != nil && handler.NeedsPackage(command) {
+					packagesToAdd = append(packagesToAdd, handler.Package)
+					if firstNeedingPackage == -1 {
+						firstNeedingPackage = len(convertedParts)
+					}
+					break
+				}
 
-			// Check if this command matches
-			if part.Command == handler.Command {
-				convertedPart := handler.Converter(part)
+				if handler.Converter == nil {
+					continue
+				}
+				convertedCommand := handler.Converter(command)
 				// Check if conversion actually changed anything
-				if convertedPart.Command != part.Command || !slices.Equal(convertedPart.Args, part.Args) {
-					convertedParts[i] = convertedPart
-					modified = true
-					converted = true
+				if convertedCommand != nil && (convertedCommand.Command != command.Command || !slices.Equal(convertedCommand.Args, command.Args)) {
+					commands[j] = convertedCommand
+					partModified = true
 					break
 				}
 			}
 		}
 
-		// If no conversion was applied, copy the original part
-		if !converted {
-			convertedParts[i] = cloneShellPart(part)
+		if partModified {
+			// Converters may chain follow-up commands with &&
+			convertedParts = append(convertedParts, splitChainedPart(joinPipeline(commands, part.Delimiter))...)
+			modified = true
+		} else {
+			// If no conversion was applied, copy the original part
+			convertedParts = append(convertedParts, cloneShellPart(part))
 		}
 	}
 
+	if len(packagesToAdd) > 0 {
+		convertedParts = addApkPackages(convertedParts, packagesToAdd, firstNeedingPackage)
+		modified = true
+	}
+
 	if modified {
-		return true, &ShellCommand{Parts: convertedParts}
+		return true, packagesToAdd, &ShellCommand{Parts: convertedParts}
+	}
+
+	return false, nil, shell
+}
+
+// addApkPackages adds packages to the first apk add command found before the part at index before,
+// or inserts a new apk add command at that index if there is none
+func addApkPackages(parts []*ShellPart, packages []string, before int) []*ShellPart {
+	for _, part := range parts[:before] {
+		if part.Command == string(ManagerApk) && slices.Contains(part.Args, SubcommandAdd) {
+			part.Args = append(part.Args, packages...)
+			return parts
+		}
 	}
 
-	return false, shell
+	apkPart := &ShellPart{
+		Command:   string(ManagerApk),
+		Args:      append([]string{SubcommandAdd, ApkNoCacheFlag}, packages...),
+		Delimiter: "&&",
+	}
+	return slices.Insert(parts, before, apkPart)
+}
+
+// replaceWithApkPackages adds packages replacing removed commands to the parts left, like addApkPackages,
+// and chains the parts with && where the removed commands were
+func replaceWithApkPackages(parts []*ShellPart, packages []string, before int) []*ShellPart {
+	parts = addApkPackages(parts, packages, before)
+	for _, part := range parts[:len(parts)-1] {
+		if part.Delimiter == "" {
+			part.Delimiter = "&&"
+		}
+	}
+	parts[len(parts)-1].Delimiter = ""
+	return parts
 }
 
 // generateDockerHubVariants generates all possible Docker Hub variants for a given base
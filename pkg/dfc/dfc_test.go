@@ -1934,6 +1934,269 @@ RUN apt-get update && apt-get install -y nano`
 	}
 }
 
+func TestCommandHandlers(t *testing.T) {
+	ctx := context.Background()
+
+	pkgInstall := func(part *ShellPart) *ShellPart {
+		return &ShellPart{
+			ExtraPre:  part.ExtraPre,
+			Command:   string(ManagerApk),
+			Args:      append([]string{SubcommandAdd, ApkNoCacheFlag}, part.Args...),
+			Delimiter: part.Delimiter,
+		}
+	}
+	keepTar := func(part *ShellPart) *ShellPart {
+		return &ShellPart{Command: "tar", Args: append([]string{"--busybox"}, part.Args...), Delimiter: part.Delimiter}
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		handlers []CommandHandler
+		expected string
+	}{
+		{
+			name: "custom command",
+			raw: `FROM node
+RUN pkg-install curl git && echo done`,
+			handlers: []CommandHandler{{Command: "pkg-install", Converter: pkgInstall}},
+			expected: `FROM cgr.dev/ORG/node:latest-dev
+USER root
+RUN apk add --no-cache curl git && \
+    echo done
+`,
+		},
+		{
+			name: "override built-in handler",
+			raw: `FROM node
+RUN tar xzf archive.tar.gz`,
+			handlers: []CommandHandler{{Command: CommandGNUTar, Converter: keepTar}},
+			expected: `FROM cgr.dev/ORG/node:latest-dev
+USER root
+RUN tar --busybox xzf archive.tar.gz
+`,
+		},
+		{
+			name: "only when a package is installed",
+			raw: `FROM debian
+RUN install-deps requirements.txt
+RUN apt-get update && apt-get install -y python3-pip
+RUN install-deps requirements.txt`,
+			handlers: []CommandHandler{{
+				Command:         "install-deps",
+				OnlyIfInstalled: []string{"py3-pip"},
+				Converter: func(part *ShellPart) *ShellPart {
+					return &ShellPart{Command: "pip", Args: append([]string{"install", "-r"}, part.Args...)}
+				},
+			}},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN install-deps requirements.txt
+RUN apk add --no-cache py3-pip
+RUN pip install -r requirements.txt
+`,
+		},
+		{
+			name: "skip when a package is installed",
+			raw: `FROM debian
+RUN pkg-install curl
+RUN apt-get update && apt-get install -y legacy-tools
+RUN pkg-install curl`,
+			handlers: []CommandHandler{{
+				Command:         "pkg-install",
+				SkipIfInstalled: []string{"legacy-tools"},
+				Converter:       pkgInstall,
+			}},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache curl
+RUN apk add --no-cache legacy-tools
+RUN pkg-install curl`,
+		},
+		{
+			name: "converter leaving the command as is",
+			raw: `FROM node
+RUN pkg-install curl`,
+			handlers: []CommandHandler{{
+				Command:   "pkg-install",
+				Converter: func(*ShellPart) *ShellPart { return nil },
+			}},
+			expected: `FROM cgr.dev/ORG/node:latest-dev
+RUN pkg-install curl`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dockerfile, err := ParseDockerfile(ctx, []byte(tt.raw))
+			if err != nil {
+				t.Fatalf("ParseDockerfile(): %v", err)
+			}
+			converted, err := dockerfile.Convert(ctx, Options{CommandHandlers: tt.handlers})
+			if err != nil {
+				t.Fatalf("dockerfile.Convert(): %v", err)
+			}
+			if diff := cmp.Diff(tt.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	// Invalid handlers are rejected
+	dockerfile, err := ParseDockerfile(ctx, []byte("RUN pkg-install curl"))
+	if err != nil {
+		t.Fatalf("ParseDockerfile(): %v", err)
+	}
+	if _, err := dockerfile.Convert(ctx, Options{CommandHandlers: []CommandHandler{{Command: "pkg-install"}}}); err == nil {
+		t.Errorf("Expected error for command handler without converter")
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		raw      string
+		policy   UserPolicy
+		expected string
+	}{
+		{
+			name: "root is kept by default",
+			raw: `FROM debian:12
+RUN apt-get update && apt-get install -y curl
+COPY app /app`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache curl
+COPY app /app`,
+		},
+		{
+			name: "nonroot restored after the last converted RUN",
+			raw: `FROM debian:12
+RUN apt-get update && apt-get install -y curl
+RUN apt-get install -y git
+COPY app /app`,
+			policy: UserPolicyFinal,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache curl
+RUN apk add --no-cache git
+USER nonroot
+COPY app /app`,
+		},
+		{
+			name: "explicit USER is left alone",
+			raw: `FROM debian:12
+RUN apt-get update && apt-get install -y curl
+USER app`,
+			policy: UserPolicyFinal,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache curl
+USER app`,
+		},
+		{
+			name: "final policy only restores the final stage",
+			raw: `FROM golang:1.24 AS build
+RUN apt-get update && apt-get install -y make
+
+FROM debian:12
+RUN apt-get update && apt-get install -y ca-certificates`,
+			policy: UserPolicyFinal,
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS build
+USER root
+RUN apk add --no-cache make
+
+FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache ca-certificates
+USER nonroot
+`,
+		},
+		{
+			name: "child stage inherits the original user",
+			raw: `FROM node:20 AS base
+USER node
+RUN echo hello
+
+FROM base
+RUN apt-get update && apt-get install -y git
+CMD ["node"]`,
+			policy: UserPolicyAll,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS base
+USER node
+RUN echo hello
+
+FROM base
+USER root
+RUN apk add --no-cache git
+USER node
+ENTRYPOINT []
+CMD ["node"]
+`,
+		},
+		{
+			name: "all policy switches child stages back to root",
+			raw: `FROM debian:12 AS base
+RUN apt-get update && apt-get install -y curl
+
+FROM base
+RUN apt-get install -y git`,
+			policy: UserPolicyAll,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest AS base
+USER root
+RUN apk add --no-cache curl
+USER nonroot
+
+FROM base
+USER root
+RUN apk add --no-cache git
+USER nonroot
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dockerfile, err := ParseDockerfile(ctx, []byte(tt.raw))
+			if err != nil {
+				t.Fatalf("ParseDockerfile(): %v", err)
+			}
+			converted, err := dockerfile.Convert(ctx, Options{RestoreUser: tt.policy})
+			if err != nil {
+				t.Fatalf("dockerfile.Convert(): %v", err)
+			}
+			got := converted.String()
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			// Converting again must not add anything
+			reparsed, err := ParseDockerfile(ctx, []byte(got))
+			if err != nil {
+				t.Fatalf("ParseDockerfile(): %v", err)
+			}
+			again, err := reparsed.Convert(ctx, Options{RestoreUser: tt.policy})
+			if err != nil {
+				t.Fatalf("dockerfile.Convert(): %v", err)
+			}
+			if diff := cmp.Diff(got, again.String()); diff != "" {
+				t.Errorf("second conversion not idempotent (-first, +second):\n%s", diff)
+			}
+		})
+	}
+
+	// Unknown policies are rejected
+	dockerfile, err := ParseDockerfile(ctx, []byte("FROM debian:12"))
+	if err != nil {
+		t.Fatalf("ParseDockerfile(): %v", err)
+	}
+	if _, err := dockerfile.Convert(ctx, Options{RestoreUser: "sometimes"}); err == nil {
+		t.Errorf("Expected error for unknown user policy")
+	}
+}
+
 func TestParsePackageSpec(t *testing.T) {
 	type args struct {
 		manager    Manager
@@ -0,0 +1,150 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Linkage is what the binaries of a stage need from the C runtime of their image
+type Linkage int
+
+// Linkages, from the least to the most demanding
+const (
+	LinkageStatic Linkage = iota // Statically linked, no C runtime needed
+	LinkageGlibc                 // Dynamically linked against glibc
+	LinkageCC                    // Dynamically linked against glibc and libstdc++/libgcc
+)
+
+// linkageImages are the minimal Chainguard images providing each linkage
+var linkageImages = map[Linkage]string{
+	LinkageStatic: "static",
+	LinkageGlibc:  "glibc-dynamic",
+	LinkageCC:     "cc-dynamic",
+}
+
+// distrolessPattern matches the distroless images without a language runtime, like
+// gcr.io/distroless/static-debian12 or distroless/cc, capturing the variant
+var distrolessPattern = regexp.MustCompile(`^(?:[^/]+/)*distroless/(static|base|base-nossl|cc)(?:-debian\d+)?$`)
+
+// distrolessRuntimePattern matches the distroless images with a language runtime, like
+// gcr.io/distroless/nodejs20-debian12, capturing the runtime and its version
+var distrolessRuntimePattern = regexp.MustCompile(`^(?:[^/]+/)*distroless/(nodejs|python|java)(\d+)(?:-debian\d+)?$`)
+
+// distrolessRuntimes are the Chainguard images providing the language runtimes of distroless
+var distrolessRuntimes = map[string]string{
+	"nodejs": "node",
+	"python": "python",
+	"java":   "jre",
+}
+
+// distrolessRuntimeArgs are the arguments the entrypoint of the distroless runtime images passes
+// before CMD, unlike the entrypoint of their Chainguard image: distroless java runs java -jar
+var distrolessRuntimeArgs = map[string][]string{
+	"java": {"-jar"},
+}
+
+// distrolessVariants are the linkages provided by the distroless variants, and by scratch,
+// which is only converted with Options.ConvertScratch
+var distrolessVariants = map[string]Linkage{
+	"static":     LinkageStatic,
+	"base":       LinkageGlibc,
+	"base-nossl": LinkageGlibc,
+	"cc":         LinkageCC,
+	"scratch":    LinkageStatic,
+}
+
+// resolveDistroless resolves a distroless image (or scratch) to the Chainguard image providing
+// the same linkage, and the language runtime variants (nodejs, python3, java) to the image of
+// the runtime, whose tag is calculated from the version in the name (nodejs20 gives node:20)
+// rather than from the distroless tag. Otherwise, the tag is calculated like for other images,
+// with the tag rules and strategy.
+func resolveDistroless(base string) (ImageMapping, bool) {
+	if match := distrolessRuntimePattern.FindStringSubmatch(base); match != nil {
+		return ImageMapping{Image: distrolessRuntimes[match[1]], Version: match[2], Rule: MappingRuleDistroless, Key: match[1] + match[2]}, true
+	}
+	variant := base
+	if match := distrolessPattern.FindStringSubmatch(base); match != nil {
+		variant = match[1]
+	} else if base != "scratch" {
+		return ImageMapping{}, false
+	}
+	return ImageMapping{Image: linkageImages[distrolessVariants[variant]], Rule: MappingRuleDistroless, Key: variant}, true
+}
+
+// linkNeed is the linkage needed by the binaries of a stage, and why
+type linkNeed struct {
+	Linkage Linkage
+	Stage   string // Stage the binaries are built in
+	Reason  string // What makes them need the linkage
+}
+
+// linkageSignals are the commands and settings of a build stage that make the binaries it builds
+// dynamically linked, in order of precedence
+var linkageSignals = []struct {
+	Pattern *regexp.Regexp
+	Linkage Linkage
+	Reason  string
+}{
+	{regexp.MustCompile(`(?:^|[\s/;&|])(?:g|clang|c)\+\+(?:\s|$)`), LinkageCC, "uses a C++ compiler"},
+	{regexp.MustCompile(`libstdc\+\+`), LinkageCC, "uses libstdc++"},
+	{regexp.MustCompile(`\bcargo\s+(?:build|install)\b`), LinkageCC, "builds Rust binaries, which link against libgcc"},
+	{regexp.MustCompile(`\bCGO_ENABLED=1\b`), LinkageGlibc, "builds with CGO_ENABLED=1"},
+	{regexp.MustCompile(`(?:^|[\s/;&|])(?:gcc|cc)(?:\s|$)`), LinkageGlibc, "uses a C compiler"},
+}
+
+// stageLinkage returns the linkage needed by each stage with binaries built in other stages:
+// the stages it copies files from, along with the stages they are built on
+func stageLinkage(lines []*DockerfileLine, graph *StageGraph) map[int]linkNeed {
+	// Find what each stage builds first
+	built := make(map[int]linkNeed)
+	for _, line := range lines {
+		if directive, _ := stageReferences(line.Raw); directive != DirectiveRun && directive != "ENV" {
+			continue
+		}
+		for _, signal := range linkageSignals {
+			if signal.Linkage > built[line.Stage].Linkage && signal.Pattern.MatchString(line.Raw) {
+				built[line.Stage] = linkNeed{Linkage: signal.Linkage, Reason: signal.Reason}
+			}
+		}
+	}
+
+	needs := make(map[int]linkNeed)
+	for _, stage := range graph.Stages {
+		for _, source := range stage.CopyFrom {
+			// The binaries built in a stage also depend on the stages it is built on
+			for s := graph.Stage(source); s != nil; s = graph.Stage(s.Parent) {
+				if need := built[s.Number]; need.Linkage > needs[stage.Number].Linkage {
+					need.Stage = graph.Stage(source).Name()
+					needs[stage.Number] = need
+				}
+			}
+		}
+	}
+	return needs
+}
+
+// upgradeLinkage replaces the image of a distroless mapping with one providing the linkage the
+// stage needs, when the variant does not, and returns a note explaining why
+func upgradeLinkage(mapping ImageMapping, need linkNeed) (ImageMapping, string) {
+	variant, ok := distrolessVariants[mapping.Key]
+	if mapping.Rule != MappingRuleDistroless || !ok || need.Linkage <= variant {
+		return mapping, ""
+	}
+	original := mapping.Image
+	if mapping.Key == "scratch" {
+		original = "scratch"
+	}
+	mapping.Image = linkageImages[need.Linkage]
+	return mapping, fmt.Sprintf("using %s rather than %s: the binaries copied from stage %s are dynamically linked (the stage %s)", mapping.Image, original, need.Stage, need.Reason)
+}
+
+// shouldConvertScratch determines if a FROM scratch line should be converted, which is only the
+// case with Options.ConvertScratch when the stage copies in dynamically linked binaries
+func shouldConvertScratch(from *FromDetails, need linkNeed, opts Options) bool {
+	return opts.ConvertScratch && from.Base == "scratch" && need.Linkage > LinkageStatic
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveDistroless(t *testing.T) {
+	testCases := []struct {
+		base     string
+		expected string
+	}{
+		{base: "gcr.io/distroless/static", expected: "static"},
+		{base: "gcr.io/distroless/static-debian12", expected: "static"},
+		{base: "gcr.io/distroless/base-debian12", expected: "glibc-dynamic"},
+		{base: "gcr.io/distroless/base-nossl-debian12", expected: "glibc-dynamic"},
+		{base: "distroless/base-debian12", expected: "glibc-dynamic"},
+		{base: "gcr.io/distroless/cc-debian11", expected: "cc-dynamic"},
+		{base: "scratch", expected: "static"},
+		{base: "gcr.io/distroless/nodejs20-debian12", expected: "node"},
+		{base: "gcr.io/distroless/python3-debian12", expected: "python"},
+		{base: "gcr.io/distroless/java17-debian12", expected: "jre"},
+		{base: "gcr.io/distroless/java-base-debian12", expected: ""},
+		{base: "ghcr.io/acme/static", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.base, func(t *testing.T) {
+			mapping, ok := resolveDistroless(tc.base)
+			if ok != (tc.expected != "") || mapping.Image != tc.expected {
+				t.Errorf("resolveDistroless(%q) = %q, %v, want %q", tc.base, mapping.Image, ok, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDistrolessConversion(t *testing.T) {
+	testCases := []struct {
+		name           string
+		raw            string
+		convertScratch bool
+		mappings       MappingsConfig
+		expected       string
+		expectedNotes  []string
+	}{
+		{
+			name: "static go binary",
+			raw: `FROM golang:1.24 AS build
+RUN CGO_ENABLED=0 go build -o /app
+
+FROM gcr.io/distroless/static-debian12:nonroot
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS build
+RUN CGO_ENABLED=0 go build -o /app
+
+FROM cgr.dev/ORG/static:latest
+COPY --from=build /app /app`,
+		},
+		{
+			name: "cgo binary on static",
+			raw: `FROM golang:1.24 AS build
+ENV CGO_ENABLED=1
+RUN go build -o /app
+
+FROM gcr.io/distroless/static-debian12
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS build
+ENV CGO_ENABLED=1
+RUN go build -o /app
+
+FROM cgr.dev/ORG/glibc-dynamic:latest
+COPY --from=build /app /app`,
+			expectedNotes: []string{"using glibc-dynamic rather than static: the binaries copied from stage build are dynamically linked (the stage builds with CGO_ENABLED=1)"},
+		},
+		{
+			name: "c++ binary on base",
+			raw: `FROM debian:bookworm AS build
+RUN g++ -O2 -o /app main.cpp
+
+FROM gcr.io/distroless/base-debian12
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest AS build
+RUN g++ -O2 -o /app main.cpp
+
+FROM cgr.dev/ORG/cc-dynamic:latest
+COPY --from=build /app /app`,
+			expectedNotes: []string{"using cc-dynamic rather than glibc-dynamic: the binaries copied from stage build are dynamically linked (the stage uses a C++ compiler)"},
+		},
+		{
+			name: "cc variant",
+			raw: `FROM gcr.io/distroless/cc-debian12
+COPY app /app`,
+			expected: `FROM cgr.dev/ORG/cc-dynamic:latest
+COPY app /app`,
+		},
+		{
+			name: "runtime variant on a dynamically linked build",
+			raw: `FROM node:20 AS build
+RUN npm ci && gcc -o /addon.node addon.c
+
+FROM gcr.io/distroless/nodejs20-debian12:nonroot
+COPY --from=build /addon.node /app/addon.node
+CMD ["index.js"]`,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS build
+RUN npm ci && gcc -o /addon.node addon.c
+
+FROM cgr.dev/ORG/node:20
+COPY --from=build /addon.node /app/addon.node
+CMD ["index.js"]`,
+		},
+		{
+			name: "scratch left alone by default",
+			raw: `FROM rust:1.85 AS build
+RUN cargo build --release
+
+FROM scratch
+COPY --from=build /target/release/app /app`,
+			expected: `FROM cgr.dev/ORG/rust:1.85-dev AS build
+RUN cargo build --release
+
+FROM scratch
+COPY --from=build /target/release/app /app`,
+		},
+		{
+			name: "scratch with dynamically linked binaries",
+			raw: `FROM rust:1.85 AS build
+RUN cargo build --release
+
+FROM scratch
+COPY --from=build /target/release/app /app`,
+			convertScratch: true,
+			expected: `FROM cgr.dev/ORG/rust:1.85-dev AS build
+RUN cargo build --release
+
+FROM cgr.dev/ORG/cc-dynamic:latest
+COPY --from=build /target/release/app /app`,
+			expectedNotes: []string{"using cc-dynamic rather than scratch: the binaries copied from stage build are dynamically linked (the stage builds Rust binaries, which link against libgcc)"},
+		},
+		{
+			name: "scratch with static binaries",
+			raw: `FROM golang:1.24 AS build
+RUN CGO_ENABLED=0 go build -o /app
+
+FROM scratch
+COPY --from=build /app /app`,
+			convertScratch: true,
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS build
+RUN CGO_ENABLED=0 go build -o /app
+
+FROM scratch
+COPY --from=build /app /app`,
+		},
+		{
+			name: "user mapping for distroless images",
+			raw: `FROM gcr.io/distroless/static-debian12
+COPY app /app`,
+			mappings: MappingsConfig{Images: map[string]string{"gcr.io/distroless/*": "acme-runtime"}},
+			expected: `FROM cgr.dev/ORG/acme-runtime:latest
+COPY app /app`,
+		},
+		{
+			name: "tag rules",
+			raw: `FROM gcr.io/distroless/base-debian12:nonroot
+COPY app /app`,
+			mappings: MappingsConfig{Tags: map[string][]TagRule{"glibc-dynamic": {{Match: "nonroot", Replace: "latest-glibc"}}}},
+			expected: `FROM cgr.dev/ORG/glibc-dynamic:latest-glibc
+COPY app /app`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{ConvertScratch: tc.convertScratch, ExtraMappings: tc.mappings})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From != nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Commands downloading files
+const (
+	CommandCurl = "curl"
+	CommandWget = "wget"
+)
+
+// releaseVersion matches the version in a release URL: a version number, or an ARG variable
+// holding it, optionally prefixed with v. Other values (like $(curl .../stable.txt)) are matched
+// without capturing a version.
+const releaseVersion = `v?(?:(\d+(?:\.\d+)*|\$\{?\w+\}?)|\$\([^)]*\)|[^/]+)`
+
+// defaultDownloads are the release binaries packaged in Wolfi, which are installed with apk instead of
+// being downloaded along with the downloads from the mappings (the mappings take precedence for the
+// same pattern)
+var defaultDownloads = map[string]string{
+	`^https://(?:dl\.k8s\.io|storage\.googleapis\.com/kubernetes-release)/release/` + releaseVersion + `/bin/linux/[^/]+/kubectl$`: "kubectl",
+	`^https://get\.helm\.sh/helm-` + releaseVersion + `-linux-[^/]+\.tar\.gz$`:                                                     "helm",
+	`^https://releases\.hashicorp\.com/terraform/` + releaseVersion + `/terraform_[^/]+_linux_[^/]+\.zip$`:                         "terraform",
+	`^https://github\.com/mikefarah/yq/releases/(?:latest/download|download/` + releaseVersion + `)/yq_linux_[^/]+$`:               "yq",
+	`^https://github\.com/(?:stedolan|jqlang)/jq/releases/(?:latest/download|download/jq-` + releaseVersion + `)/jq-linux[^/]*$`:   "jq",
+	`^https://github\.com/tianon/gosu/releases/download/` + releaseVersion + `/gosu-[^/]+$`:                                        "gosu",
+	`^https://github\.com/krallin/tini/releases/download/` + releaseVersion + `/tini(?:-static)?(?:-[^/]+)?$`:                      "tini",
+}
+
+// downloadPlumbingCommands are the commands that install a downloaded file, which are removed with
+// the download when they refer to it
+var downloadPlumbingCommands = []string{"chmod", "chown", "tar", "unzip", "gunzip", "mv", "cp", "install", "ln", "rm", "sha256sum", "sha512sum", "echo"}
+
+// download maps the URLs of a release binary to the package providing it
+type download struct {
+	Pattern *regexp.Regexp
+	Package string
+}
+
+// validateDownloads checks that the URL patterns of the downloads are valid regular expressions
+func validateDownloads(downloads map[string]string) error {
+	for pattern := range downloads {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid download pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// resolveDownloads returns the downloads from the mappings, then the built-in ones
+func resolveDownloads(downloads map[string]string) []download {
+	var result []download
+	for _, patterns := range []map[string]string{downloads, defaultDownloads} {
+		keys := make([]string, 0, len(patterns))
+		for pattern := range patterns {
+			keys = append(keys, pattern)
+		}
+		sort.Strings(keys)
+		for _, pattern := range keys {
+			if re, err := regexp.Compile(pattern); err == nil {
+				result = append(result, download{Pattern: re, Package: patterns[pattern]})
+			}
+		}
+	}
+	return result
+}
+
+// versionVariable matches a version held by an ARG or ENV variable, like ${KUBECTL_VERSION}
+var versionVariable = regexp.MustCompile(`^\$\{?(\w+)\}?$`)
+
+// match returns the apk package spec for a URL: the package, with the version captured by the
+// version group of the pattern (or its first group) when it has one. The v prefix of versions held
+// by variables is stripped by the shell, unless the URL already has it before the variable.
+func (d download) match(url string) (string, bool) {
+	match := d.Pattern.FindStringSubmatchIndex(url)
+	if match == nil {
+		return "", false
+	}
+	group := 0
+	if i := d.Pattern.SubexpIndex("version"); i > 0 {
+		group = i
+	} else if len(match) > 2 {
+		group = 1
+	}
+	version := ""
+	if start, end := match[2*group], match[2*group+1]; group > 0 && start >= 0 {
+		version = url[start:end]
+		if variable := versionVariable.FindStringSubmatch(version); variable != nil && (start == 0 || url[start-1] != 'v') {
+			version = "${" + variable[1] + "#v}"
+		}
+	}
+	return createApkPackageSpec(d.Package, PackageSpec{Version: version}), true
+}
+
+// downloadedFile is a file downloaded by curl or wget
+type downloadedFile struct {
+	URL    string
+	Output string // Path the file is saved to, - for stdout
+}
+
+// parseDownload parses a curl or wget command, and returns the URL and output of the download
+func parseDownload(command *ShellPart) (downloadedFile, bool) {
+	var outputFlag byte
+	switch command.Command {
+	case CommandCurl:
+		outputFlag = 'o'
+	case CommandWget:
+		outputFlag = 'O'
+	default:
+		return downloadedFile{}, false
+	}
+
+	var file downloadedFile
+	remoteName := command.Command == CommandWget
+	for i := 0; i < len(command.Args); i++ {
+		arg := strings.Trim(command.Args[i], `"'`)
+		switch {
+		case strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://"):
+			if file.URL == "" {
+				file.URL = arg
+			}
+		case arg == ">" && i+1 < len(command.Args):
+			file.Output = strings.Trim(command.Args[i+1], `"'`)
+			i++
+		case strings.HasPrefix(arg, "--output=") || strings.HasPrefix(arg, "--output-document="):
+			_, file.Output, _ = strings.Cut(arg, "=")
+		case (arg == "--output" || arg == "--output-document") && i+1 < len(command.Args):
+			file.Output = strings.Trim(command.Args[i+1], `"'`)
+			i++
+		case arg == "--remote-name":
+			remoteName = true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			// Short options can be combined, like -fsSLo file or -qO-
+			if command.Command == CommandCurl && strings.ContainsRune(arg, 'O') {
+				remoteName = true
+			}
+			if j := strings.IndexByte(arg, outputFlag); j > 0 {
+				if j < len(arg)-1 {
+					file.Output = arg[j+1:]
+				} else if i+1 < len(command.Args) {
+					file.Output = strings.Trim(command.Args[i+1], `"'`)
+					i++
+				}
+			}
+		}
+	}
+	if file.URL == "" {
+		return downloadedFile{}, false
+	}
+	if file.Output == "" {
+		if remoteName {
+			file.Output = path.Base(file.URL)
+		} else {
+			file.Output = "-"
+		}
+	}
+	return file, true
+}
+
+// refersTo reports whether an argument refers to a file with one of the names
+func refersTo(arg string, names []string) bool {
+	for _, field := range strings.Fields(strings.Trim(arg, `"'`)) {
+		if slices.Contains(names, path.Base(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readsStdin reports whether a checksum command checks the checksums piped into it
+func readsStdin(command *ShellPart) bool {
+	if command.Command != "sha256sum" && command.Command != "sha512sum" {
+		return false
+	}
+	for _, arg := range command.Args {
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return arg == "-"
+		}
+	}
+	return true
+}
+
+// installedDownload is a downloaded file replaced with a package
+type installedDownload struct {
+	Package string   // Name of the package
+	Names   []string // Names the downloaded file goes by
+	Path    string   // Absolute path the file is installed to, if known
+}
+
+// archiveExtensions are the extensions of downloaded files that are extracted rather than installed
+var archiveExtensions = []string{".tar", ".gz", ".tgz", ".zip", ".xz", ".bz2", ".zst"}
+
+// binDirectories are the directories of the default PATH, where files are moved to keep their name
+var binDirectories = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// installPath returns the path a file is installed to, if it is an absolute path outside of /tmp
+// and not an archive
+func installPath(file string) string {
+	if !path.IsAbs(file) || strings.HasPrefix(file, "/tmp/") || slices.Contains(archiveExtensions, path.Ext(file)) {
+		return ""
+	}
+	return path.Clean(file)
+}
+
+// removeDownloadPlumbing removes the commands of a shell part that only install or check the
+// downloaded files, recording where mv, cp and install put them. It returns the part left, nil if
+// nothing is left, and whether anything was removed.
+func removeDownloadPlumbing(part *ShellPart, installed []*installedDownload) (*ShellPart, bool) {
+	var names []string
+	for _, file := range installed {
+		names = append(names, file.Names...)
+	}
+
+	commands := splitPipeline(part)
+	if len(commands) == 1 && part.Command == "rm" {
+		// Only the downloaded files are removed from rm commands
+		result := cloneShellPart(part)
+		result.Args = slices.DeleteFunc(result.Args, func(arg string) bool { return refersTo(arg, names) })
+		switch {
+		case len(result.Args) == len(part.Args):
+			return part, false
+		case !slices.ContainsFunc(result.Args, func(arg string) bool { return !strings.HasPrefix(arg, "-") }):
+			return nil, true
+		}
+		return result, true
+	}
+
+	for _, command := range commands {
+		if !slices.Contains(downloadPlumbingCommands, command.Command) {
+			return part, false
+		}
+		if !readsStdin(command) && !slices.ContainsFunc(command.Args, func(arg string) bool { return refersTo(arg, names) }) {
+			return part, false
+		}
+	}
+	// echo is only plumbing when checksums are piped into sha256sum -c
+	if commands[0].Command == "echo" && len(commands) == 1 {
+		return part, false
+	}
+
+	for _, command := range commands {
+		if command.Command != "mv" && command.Command != "cp" && command.Command != "install" {
+			continue
+		}
+		var files []string
+		for _, arg := range command.Args {
+			if !strings.HasPrefix(arg, "-") {
+				files = append(files, strings.Trim(arg, `"'`))
+			}
+		}
+		if len(files) < 2 {
+			continue
+		}
+		source, dest := files[len(files)-2], files[len(files)-1]
+		if strings.HasSuffix(dest, "/") || slices.Contains(binDirectories, path.Clean(dest)) {
+			dest = path.Join(dest, path.Base(source))
+		}
+		for _, file := range installed {
+			if refersTo(source, file.Names) {
+				file.Names = append(file.Names, path.Base(dest))
+				file.Path = installPath(dest)
+			}
+		}
+	}
+	return nil, true
+}
+
+// convertDownloads replaces the downloads of release binaries packaged in Wolfi with apk add, and
+// removes the commands installing the downloaded files (chmod, tar, mv...). The paths the files
+// were installed to are kept as links to the packaged binaries, unless they are on the default PATH
+// under the same name. It returns the packages installed
+// and notes about the replaced downloads.
+func convertDownloads(shell *ShellCommand, downloads []download) (bool, []string, *ShellCommand, []string) {
+	if shell == nil || len(shell.Parts) == 0 {
+		return false, nil, shell, nil
+	}
+
+	var packages, notes []string
+	var installed []*installedDownload
+	newParts := make([]*ShellPart, 0, len(shell.Parts))
+	first := -1
+	for _, part := range shell.Parts {
+		commands := splitPipeline(part)
+		if file, ok := parseDownload(commands[0]); ok {
+			if pkg, ok := matchDownload(file.URL, downloads); ok {
+				if first == -1 {
+					first = len(newParts)
+				}
+				if !slices.Contains(packages, pkg) {
+					packages = append(packages, pkg)
+				}
+				notes = append(notes, fmt.Sprintf("installed %s instead of downloading %s", pkg, file.URL))
+				name, _, _ := strings.Cut(pkg, "=")
+				download := &installedDownload{Package: name, Names: []string{name, path.Base(file.URL)}}
+				if file.Output != "-" {
+					download.Names = append(download.Names, path.Base(file.Output))
+					download.Path = installPath(file.Output)
+				}
+				installed = append(installed, download)
+				continue
+			}
+		}
+		if len(installed) > 0 {
+			if left, removed := removeDownloadPlumbing(part, installed); removed {
+				if left != nil {
+					newParts = append(newParts, left)
+				}
+				continue
+			}
+		}
+		newParts = append(newParts, cloneShellPart(part))
+	}
+	if len(packages) == 0 {
+		return false, nil, shell, nil
+	}
+
+	// Packages install their binaries to /usr/bin, the paths of the downloads link to them, unless
+	// they are the same command on the default PATH
+	var links []*ShellPart
+	for _, file := range installed {
+		binary := path.Join("/usr/bin", file.Package)
+		onPath := path.Base(file.Path) == file.Package && slices.Contains(binDirectories, path.Dir(file.Path))
+		if file.Path != "" && !onPath {
+			links = append(links, &ShellPart{Command: "ln", Args: []string{"-sf", binary, file.Path}, Delimiter: "&&"})
+		}
+	}
+	newParts = slices.Insert(newParts, first, links...)
+
+	// The packages are installed where the first download was
+	return true, packages, &ShellCommand{Parts: replaceWithApkPackages(newParts, packages, first)}, notes
+}
+
+// matchDownload returns the apk package spec for the URL of a download, if it is packaged
+func matchDownload(url string, downloads []download) (string, bool) {
+	for _, d := range downloads {
+		if pkg, ok := d.match(url); ok {
+			return pkg, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvertDownloads(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		mappings      MappingsConfig
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "binary downloaded with curl",
+			raw: `FROM debian:12
+RUN curl -fsSL -o /usr/local/bin/kubectl https://dl.k8s.io/release/v1.30.2/bin/linux/amd64/kubectl && \
+    chmod +x /usr/local/bin/kubectl`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache kubectl=~1.30.2
+`,
+			expectedNotes: []string{"installed kubectl=~1.30.2 instead of downloading https://dl.k8s.io/release/v1.30.2/bin/linux/amd64/kubectl"},
+		},
+		{
+			name: "archive added to the converted apk add",
+			raw: `FROM debian:12
+ARG HELM_VERSION=3.14.0
+RUN apt-get update && apt-get install -y git && \
+    curl -fsSL https://get.helm.sh/helm-v${HELM_VERSION}-linux-amd64.tar.gz -o /tmp/helm.tar.gz && \
+    tar -xzf /tmp/helm.tar.gz -C /tmp && \
+    mv /tmp/linux-amd64/helm /usr/local/bin/helm && \
+    rm -rf /tmp/helm.tar.gz && \
+    helm version`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+ARG HELM_VERSION=3.14.0
+RUN apk add --no-cache git helm=~${HELM_VERSION} && \
+    helm version
+`,
+			expectedNotes: []string{"installed helm=~${HELM_VERSION} instead of downloading https://get.helm.sh/helm-v${HELM_VERSION}-linux-amd64.tar.gz"},
+		},
+		{
+			name: "latest release piped into tar",
+			raw: `FROM debian:12
+RUN mkdir -p /app && \
+    wget -qO- https://github.com/mikefarah/yq/releases/latest/download/yq_linux_amd64.tar.gz | tar -xz -C /usr/local/bin`,
+			mappings: MappingsConfig{Downloads: map[string]string{`^https://github\.com/mikefarah/yq/releases/latest/download/yq_linux_amd64\.tar\.gz$`: "yq"}},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN mkdir -p /app && \
+    apk add --no-cache yq
+`,
+			expectedNotes: []string{"installed yq instead of downloading https://github.com/mikefarah/yq/releases/latest/download/yq_linux_amd64.tar.gz"},
+		},
+		{
+			name: "version captured by a named group",
+			raw: `FROM debian:12
+RUN curl -L https://example.com/tools/v2.1.0/tool-linux-amd64 -o /usr/local/bin/tool && chmod 755 /usr/local/bin/tool`,
+			mappings: MappingsConfig{Downloads: map[string]string{`^https://example\.com/tools/(v)(?P<version>[\d.]+)/`: "example-tool"}},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache example-tool=~2.1.0 && \
+    ln -sf /usr/bin/example-tool /usr/local/bin/tool
+`,
+			expectedNotes: []string{"installed example-tool=~2.1.0 instead of downloading https://example.com/tools/v2.1.0/tool-linux-amd64"},
+		},
+		{
+			name: "version with a v prefix in an ARG",
+			raw: `FROM debian:12
+ARG KUBECTL_VERSION=v1.29.0
+RUN curl -LO https://dl.k8s.io/release/${KUBECTL_VERSION}/bin/linux/amd64/kubectl && \
+    install -m 0755 kubectl /usr/local/bin/ && \
+    rm kubectl`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+ARG KUBECTL_VERSION=v1.29.0
+RUN apk add --no-cache kubectl=~${KUBECTL_VERSION#v}
+`,
+			expectedNotes: []string{"installed kubectl=~${KUBECTL_VERSION#v} instead of downloading https://dl.k8s.io/release/${KUBECTL_VERSION}/bin/linux/amd64/kubectl"},
+		},
+		{
+			name: "custom output path and unrelated commands",
+			raw: `FROM debian:12
+RUN wget -O /tini https://github.com/krallin/tini/releases/download/v0.19.0/tini-amd64 && \
+    chmod +x /tini && \
+    cp /etc/app/default.conf /etc/app/app.conf && \
+    rm -rf /tmp/tini-amd64 /var/lib/apt/lists/*
+ENTRYPOINT ["/tini", "--"]`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache tini=~0.19.0 && \
+    ln -sf /usr/bin/tini /tini && \
+    cp /etc/app/default.conf /etc/app/app.conf && \
+    rm -rf /var/lib/apt/lists/*
+ENTRYPOINT ["/tini", "--"]`,
+			expectedNotes: []string{"installed tini=~0.19.0 instead of downloading https://github.com/krallin/tini/releases/download/v0.19.0/tini-amd64"},
+		},
+		{
+			name: "unknown download",
+			raw: `FROM debian:12
+RUN curl -fsSL -o /usr/local/bin/tool https://example.com/tool && chmod +x /usr/local/bin/tool`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+RUN curl -fsSL -o /usr/local/bin/tool https://example.com/tool && chmod +x /usr/local/bin/tool`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{ExtraMappings: tc.mappings})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From == nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseDownload(t *testing.T) {
+	testCases := []struct {
+		name     string
+		command  string
+		expected downloadedFile
+	}{
+		{
+			name:     "curl output",
+			command:  "curl -fsSLo /usr/local/bin/tini https://example.com/tini",
+			expected: downloadedFile{URL: "https://example.com/tini", Output: "/usr/local/bin/tini"},
+		},
+		{
+			name:     "curl remote name",
+			command:  `curl -LO "https://example.com/kubectl"`,
+			expected: downloadedFile{URL: "https://example.com/kubectl", Output: "kubectl"},
+		},
+		{
+			name:     "curl to stdout",
+			command:  "curl -sSL https://example.com/tool.tar.gz",
+			expected: downloadedFile{URL: "https://example.com/tool.tar.gz", Output: "-"},
+		},
+		{
+			name:     "curl redirected",
+			command:  "curl -sSL https://example.com/jq > /usr/bin/jq",
+			expected: downloadedFile{URL: "https://example.com/jq", Output: "/usr/bin/jq"},
+		},
+		{
+			name:     "wget remote name",
+			command:  "wget -q https://example.com/gosu-amd64",
+			expected: downloadedFile{URL: "https://example.com/gosu-amd64", Output: "gosu-amd64"},
+		},
+		{
+			name:     "wget to stdout",
+			command:  "wget -qO- https://example.com/tool.tar.gz",
+			expected: downloadedFile{URL: "https://example.com/tool.tar.gz", Output: "-"},
+		},
+		{
+			name:     "wget output document",
+			command:  "wget --output-document=/tmp/tool.zip https://example.com/tool.zip",
+			expected: downloadedFile{URL: "https://example.com/tool.zip", Output: "/tmp/tool.zip"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			shell := ParseMultilineShell(tc.command)
+			file, ok := parseDownload(shell.Parts[0])
+			if !ok {
+				t.Fatalf("parseDownload(%q) found no download", tc.command)
+			}
+			if diff := cmp.Diff(tc.expected, file); diff != "" {
+				t.Errorf("download not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateDownloads(t *testing.T) {
+	if err := validateDownloads(map[string]string{`^https://example\.com/(v[\d.]+`: "tool"}); err == nil {
+		t.Error("validateDownloads() = nil, want error for an invalid pattern")
+	}
+	if err := validateDownloads(defaultDownloads); err != nil {
+		t.Errorf("validateDownloads(defaultDownloads) = %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Directives setting the command of an image
+const (
+	DirectiveEntrypoint = "ENTRYPOINT"
+	DirectiveCmd        = "CMD"
+)
+
+// ImageMetadata describes how a Chainguard image runs
+type ImageMetadata struct {
+	Entrypoint []string `yaml:"entrypoint,omitempty"` // Default ENTRYPOINT of the image, which CMD is passed to
+	Shell      bool     `yaml:"shell,omitempty"`      // Whether the image (not its -dev variant) has /bin/sh
+}
+
+// defaultImageMetadata describes common Chainguard images, and is used for the images without
+// metadata in the mappings. The -dev variants always have a shell.
+var defaultImageMetadata = map[string]ImageMetadata{
+	"python":          {Entrypoint: []string{"/usr/bin/python"}},
+	"node":            {Entrypoint: []string{"/usr/bin/node"}},
+	"jre":             {Entrypoint: []string{"/usr/bin/java"}},
+	"go":              {Entrypoint: []string{"/usr/bin/go"}},
+	"static":          {},
+	"glibc-dynamic":   {},
+	"cc-dynamic":      {},
+	"chainguard-base": {Shell: true},
+	"wolfi-base":      {Shell: true},
+}
+
+// shellMetacharacters are the characters that need a shell to interpret a shell form command
+const shellMetacharacters = "$|&;<>*?()[]{}`'\"\\~#\n"
+
+// stageImage is the Chainguard image a stage is built on
+type stageImage struct {
+	Name     string
+	Dev      bool
+	Metadata ImageMetadata
+	Known    bool     // Whether there is metadata for the image
+	Args     bool     // Whether CMD was already arguments of the entrypoint of the original image
+	ArgsFrom []string // Arguments the entrypoint of the original image passed before CMD
+}
+
+// checkEntrypoints makes ENTRYPOINT and CMD work with the Chainguard image of each stage: CMD is
+// preceded by ENTRYPOINT [] when the image has a default entrypoint the command would be passed to,
+// and shell form commands are converted to exec form when the image has no shell (or reported when
+// they cannot be converted)
+func checkEntrypoints(lines []*DockerfileLine, metadata map[string]ImageMetadata) {
+	images := make(map[int]stageImage)
+	hasEntrypoint := make(map[int]bool)
+	lastCmd := make(map[int]*DockerfileLine)
+	for _, line := range lines {
+		if line.From != nil {
+			if line.From.Parent > 0 {
+				// Stages built on another stage have its image and entrypoint
+				images[line.Stage] = images[line.From.Parent]
+				hasEntrypoint[line.Stage] = hasEntrypoint[line.From.Parent]
+			} else if line.Converted != "" {
+				image := resolveStageImage(line.Converted, metadata)
+				// The entrypoint of the distroless runtime images is the runtime binary, like in
+				// Chainguard images
+				if match := distrolessRuntimePattern.FindStringSubmatch(line.From.Base); match != nil {
+					image.Args = true
+					image.ArgsFrom = distrolessRuntimeArgs[match[1]]
+				}
+				images[line.Stage] = image
+			}
+			continue
+		}
+		switch directive, _ := cutInstruction(lineText(line)); directive {
+		case DirectiveEntrypoint:
+			hasEntrypoint[line.Stage] = true
+		case DirectiveCmd:
+			lastCmd[line.Stage] = line
+		}
+	}
+
+	for _, line := range lines {
+		image := images[line.Stage]
+		if line.From != nil || !image.Known {
+			continue
+		}
+		text := lineText(line)
+		directive, args := cutInstruction(text)
+		if directive != DirectiveEntrypoint && directive != DirectiveCmd {
+			continue
+		}
+
+		// Commands in shell form need /bin/sh
+		if !isExecForm(args) && !image.Dev && !image.Metadata.Shell {
+			if exec, ok := toExecForm(args); ok {
+				text = directive + " " + exec
+				line.Converted = text
+				line.Notes = append(line.Notes, fmt.Sprintf("converted %s to exec form: the %s image has no shell", directive, image.Name))
+			} else {
+				line.Notes = append(line.Notes, fmt.Sprintf("%s uses shell form, but the %s image has no shell: use exec form or the -dev image", directive, image.Name))
+			}
+		}
+
+		// Commands are passed to the default entrypoint of the image, unless the stage sets its own
+		if line != lastCmd[line.Stage] || hasEntrypoint[line.Stage] {
+			continue
+		}
+		switch {
+		case len(image.ArgsFrom) > 0:
+			var command []string
+			if err := json.Unmarshal([]byte(args), &command); err != nil {
+				line.Notes = append(line.Notes, fmt.Sprintf("CMD needs to start with %s: the %s image does not pass it to its entrypoint", strings.Join(image.ArgsFrom, " "), image.Name))
+				break
+			}
+			exec, ok := execForm(append(slices.Clone(image.ArgsFrom), command...))
+			if !ok {
+				break
+			}
+			line.Converted = directive + " " + exec
+			line.Notes = append(line.Notes, fmt.Sprintf("added %s to CMD: the %s image does not pass it to its entrypoint", strings.Join(image.ArgsFrom, " "), image.Name))
+		case !image.Args && len(image.Metadata.Entrypoint) > 0:
+			line.Converted = DirectiveEntrypoint + " []\n" + text
+			line.Notes = append(line.Notes, fmt.Sprintf("added ENTRYPOINT []: the %s image runs %s by default, with CMD as its arguments", image.Name, strings.Join(image.Metadata.Entrypoint, " ")))
+		}
+	}
+}
+
+// resolveStageImage returns the Chainguard image of a converted FROM line
+func resolveStageImage(converted string, metadata map[string]ImageMetadata) stageImage {
+	fromInstruction, _, _ := strings.Cut(converted, "\n")
+	fields := strings.Fields(fromInstruction)
+	if len(fields) < 2 || strings.Contains(fields[1], "$") {
+		return stageImage{}
+	}
+	ref, _, _ := strings.Cut(fields[1], "@")
+	repo, tag := splitReference(ref)
+
+	image := stageImage{Name: path.Base(repo), Dev: strings.HasSuffix(tag, "-dev")}
+	image.Metadata, image.Known = metadata[image.Name]
+	if !image.Known {
+		image.Metadata, image.Known = defaultImageMetadata[image.Name]
+	}
+	return image
+}
+
+// lineText returns the converted instruction of a line, or the original one
+func lineText(line *DockerfileLine) string {
+	if line.Converted != "" {
+		return line.Converted
+	}
+	return line.Raw
+}
+
+// cutInstruction splits an instruction into its (upper case) directive and arguments, joining
+// continuation lines
+func cutInstruction(text string) (string, string) {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\\\n", " "))
+	directive, args, _ := strings.Cut(text, " ")
+	return strings.ToUpper(directive), strings.TrimSpace(args)
+}
+
+// toExecForm converts a shell form command to exec form, when it is a simple list of words that
+// does not need a shell to be interpreted
+func toExecForm(command string) (string, bool) {
+	if strings.ContainsAny(command, shellMetacharacters) {
+		return "", false
+	}
+	return execForm(strings.Fields(command))
+}
+
+// execForm returns the exec form of a command
+func execForm(words []string) (string, bool) {
+	if len(words) == 0 {
+		return "", false
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		data, err := json.Marshal(word)
+		if err != nil {
+			return "", false
+		}
+		quoted[i] = string(data)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]", true
+}
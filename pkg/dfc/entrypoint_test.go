@@ -0,0 +1,146 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckEntrypoints(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		mappings      MappingsConfig
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "command passed to the default entrypoint",
+			raw: `FROM python:3.12
+COPY app.py .
+CMD ["python", "app.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12
+COPY app.py .
+ENTRYPOINT []
+CMD ["python", "app.py"]
+`,
+			expectedNotes: []string{"added ENTRYPOINT []: the python image runs /usr/bin/python by default, with CMD as its arguments"},
+		},
+		{
+			name: "shell form without a shell",
+			raw: `FROM python:3.12
+CMD gunicorn --bind 0.0.0.0:8000 app:app`,
+			expected: `FROM cgr.dev/ORG/python:3.12
+ENTRYPOINT []
+CMD ["gunicorn", "--bind", "0.0.0.0:8000", "app:app"]
+`,
+			expectedNotes: []string{
+				"converted CMD to exec form: the python image has no shell",
+				"added ENTRYPOINT []: the python image runs /usr/bin/python by default, with CMD as its arguments",
+			},
+		},
+		{
+			name: "shell form needing a shell",
+			raw: `FROM node:20
+ENTRYPOINT node server.js --port $PORT`,
+			expected: `FROM cgr.dev/ORG/node:20
+ENTRYPOINT node server.js --port $PORT`,
+			expectedNotes: []string{"ENTRYPOINT uses shell form, but the node image has no shell: use exec form or the -dev image"},
+		},
+		{
+			name: "entrypoint set by the stage",
+			raw: `FROM node:20
+ENTRYPOINT ["node"]
+CMD ["server.js"]`,
+			expected: `FROM cgr.dev/ORG/node:20
+ENTRYPOINT ["node"]
+CMD ["server.js"]`,
+		},
+		{
+			name: "distroless runtime already had the entrypoint",
+			raw: `FROM gcr.io/distroless/nodejs20-debian12
+COPY index.js .
+CMD ["index.js"]`,
+			expected: `FROM cgr.dev/ORG/node:20
+COPY index.js .
+CMD ["index.js"]`,
+		},
+		{
+			name: "shell available in -dev images",
+			raw: `FROM python:3.12
+RUN pip install flask
+CMD flask run`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+RUN pip install flask
+ENTRYPOINT []
+CMD flask run
+`,
+			expectedNotes: []string{"added ENTRYPOINT []: the python image runs /usr/bin/python by default, with CMD as its arguments"},
+		},
+		{
+			name: "metadata from mappings",
+			raw: `FROM nginx:1.25
+CMD nginx -g daemon-off`,
+			mappings: MappingsConfig{Metadata: map[string]ImageMetadata{"nginx": {Entrypoint: []string{"/usr/sbin/nginx"}}}},
+			expected: `FROM cgr.dev/ORG/nginx:1.25
+ENTRYPOINT []
+CMD ["nginx", "-g", "daemon-off"]
+`,
+			expectedNotes: []string{
+				"converted CMD to exec form: the nginx image has no shell",
+				"added ENTRYPOINT []: the nginx image runs /usr/sbin/nginx by default, with CMD as its arguments",
+			},
+		},
+		{
+			name: "distroless java passed CMD to java -jar",
+			raw: `FROM gcr.io/distroless/java17-debian12
+COPY app.jar /app.jar
+CMD ["/app.jar"]`,
+			expected: `FROM cgr.dev/ORG/jre:openjdk-17
+COPY app.jar /app.jar
+CMD ["-jar", "/app.jar"]
+`,
+			expectedNotes: []string{"added -jar to CMD: the jre image does not pass it to its entrypoint"},
+		},
+		{
+			name: "unknown image",
+			raw: `FROM redis:7
+CMD redis-server --appendonly yes`,
+			expected: `FROM cgr.dev/ORG/redis-sentinel:7
+CMD redis-server --appendonly yes`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{ExtraMappings: tc.mappings})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From == nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// convertExternalReferences maps the images referenced by COPY --from and RUN --mount=from
+// through the image mappings, like the images of FROM lines, and notes copied paths that are
+// elsewhere in the mapped images according to their path rewrites
+func convertExternalReferences(ctx context.Context, line *DockerfileLine, graph *StageGraph, opts Options) {
+	directive, refs := stageReferences(line.Raw)
+	if len(refs) == 0 {
+		return
+	}
+
+	text := line.Converted
+	if text == "" {
+		text = line.Raw
+	}
+	paths := referencedPaths(line.Raw)
+
+	converted := text
+	for _, ref := range refs {
+		if graph.referencedStage(ref, line.Stage) != nil || strings.Contains(ref, "$") || ref == "scratch" {
+			continue
+		}
+
+		image := convertImageReference(ctx, parseFromDetails(ref), false, linkNeed{}, opts)
+		imageRef := image.Ref
+		if imageRef == ref {
+			continue
+		}
+		refRegex := regexp.MustCompile(`(--from=|[,=]from=)` + regexp.QuoteMeta(ref) + `(,|\s|$)`)
+		converted = refRegex.ReplaceAllString(converted, "${1}"+imageRef+"${2}")
+		line.Notes = append(line.Notes, fmt.Sprintf("mapped %s image %s to %s", directive, ref, imageRef))
+		line.Notes = append(line.Notes, image.Notes...)
+
+		rewrites := resolvePathRewrites(image.Mapping.Image, image.Tag, opts.ExtraMappings.Paths)
+		for _, path := range paths[ref] {
+			for _, rewrite := range rewrites {
+				if rewritten, changes := rewrite.rewrite(path); len(changes) > 0 {
+					line.Notes = append(line.Notes, fmt.Sprintf("check that %s exists in %s: the image has it at %s", path, imageRef, rewritten))
+					break
+				}
+			}
+		}
+	}
+
+	if converted != text {
+		line.Converted = converted
+	}
+}
+
+// referencedPaths returns the paths copied by COPY --from or mounted by RUN --mount=from, by reference
+func referencedPaths(raw string) map[string][]string {
+	var fields []string
+	for _, field := range strings.Fields(raw) {
+		if field != "\\" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	paths := make(map[string][]string)
+	i := 1
+	var copyFrom string
+	for ; i < len(fields) && strings.HasPrefix(fields[i], "--"); i++ {
+		name, value, _ := strings.Cut(fields[i], "=")
+		switch name {
+		case FlagFrom:
+			copyFrom = value
+		case FlagMount:
+			var from, source string
+			for _, option := range strings.Split(value, ",") {
+				key, val, _ := strings.Cut(option, "=")
+				switch key {
+				case "from":
+					from = val
+				case "source", "src":
+					source = val
+				}
+			}
+			if from != "" && source != "" {
+				paths[from] = append(paths[from], source)
+			}
+		}
+	}
+
+	// COPY --from=<ref> <src>... <dest>
+	if strings.ToUpper(fields[0]) == DirectiveCopy && copyFrom != "" && len(fields)-i >= 2 {
+		paths[copyFrom] = append(paths[copyFrom], fields[i:len(fields)-1]...)
+	}
+	return paths
+}
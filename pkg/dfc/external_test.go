@@ -0,0 +1,94 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvertExternalReferences(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "COPY from image",
+			raw: `FROM golang:1.24 AS build
+COPY --from=nginx:1.25 /usr/share/nginx/html /html`,
+			expected: `FROM cgr.dev/ORG/go:1.24 AS build
+COPY --from=cgr.dev/ORG/nginx:1.25 /usr/share/nginx/html /html
+`,
+			expectedNotes: []string{"mapped COPY image nginx:1.25 to cgr.dev/ORG/nginx:1.25"},
+		},
+		{
+			name: "COPY from image with digest and differing path",
+			raw: `FROM debian:12
+COPY --from=python:3.12-slim@sha256:a866731a6b71c4a194a845d86e06568725e430ed21821d0c52e4efb385cf6c6f --chown=app /usr/local/lib/python3.12 /usr/local/lib/python3.12`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+COPY --from=cgr.dev/ORG/python:3.12 --chown=app /usr/local/lib/python3.12 /usr/local/lib/python3.12
+`,
+			expectedNotes: []string{
+				"mapped COPY image python:3.12-slim@sha256:a866731a6b71c4a194a845d86e06568725e430ed21821d0c52e4efb385cf6c6f to cgr.dev/ORG/python:3.12",
+				"check that /usr/local/lib/python3.12 exists in cgr.dev/ORG/python:3.12: the image has it at /usr/lib/python3.12",
+			},
+		},
+		{
+			name: "RUN mount from image",
+			raw: `FROM debian:12
+RUN --mount=type=bind,from=golang:1.24,source=/usr/local/go,target=/go /go/bin/go version`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+RUN --mount=type=bind,from=cgr.dev/ORG/go:1.24,source=/usr/local/go,target=/go /go/bin/go version
+`,
+			expectedNotes: []string{
+				"mapped RUN image golang:1.24 to cgr.dev/ORG/go:1.24",
+				"check that /usr/local/go exists in cgr.dev/ORG/go:1.24: the image has it at /usr/lib/go",
+			},
+		},
+		{
+			name: "stage references are left alone",
+			raw: `FROM golang:1.24 AS build
+FROM scratch
+COPY --from=build /app /app
+COPY --from=0 /app /app2`,
+			expected: `FROM cgr.dev/ORG/go:1.24 AS build
+FROM scratch
+COPY --from=build /app /app
+COPY --from=0 /app /app2`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From == nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RegexMappingPrefix marks image mapping keys that are regular expressions, matched against the
+// full image path (without tag), e.g. "regex:^ghcr\.io/acme/.+-(api|worker)$"
+const RegexMappingPrefix = "regex:"
+
+// globChars are the characters that make an image mapping key a glob pattern
+const globChars = "*?["
+
+// MappingRule identifies the image mapping rule that matched an image reference
+type MappingRule string
+
+// Image mapping rules, in order of precedence
+const (
+	MappingRuleNone         MappingRule = ""              // No mapping matched, the basename is used as is
+	MappingRuleExactTag     MappingRule = "exact-tag"     // The key is the full reference with tag, e.g. node:20
+	MappingRuleExact        MappingRule = "exact"         // The key is the full reference without tag
+	MappingRuleBasename     MappingRule = "basename"      // The key is the last path component of the reference
+	MappingRuleDockerHub    MappingRule = "docker-hub"    // The key is a Docker Hub variant of the reference, e.g. docker.io/library/node
+	MappingRuleNormalized   MappingRule = "normalized"    // The key is the reference without Docker Hub domain or library/ prefix
+	MappingRulePathGlob     MappingRule = "path-glob"     // The key is a glob pattern matching the full path, e.g. ghcr.io/acme/*
+	MappingRuleRegex        MappingRule = "regex"         // The key is a regular expression matching the full path
+	MappingRuleDistroless   MappingRule = "distroless"    // The reference is a distroless image (or scratch), the key is its variant
+	MappingRuleBasenameGlob MappingRule = "basename-glob" // The key is a glob pattern matching the basename, e.g. golang*
+)
+
+// ImageMapping is the result of resolving an image reference against the image mappings
+type ImageMapping struct {
+	Image   string      // Chainguard image name
+	Tag     string      // Tag set by the mapping, empty if the tag is calculated
+	Version string      // Version named by the reference, calculating the tag instead of its tag
+	Rule    MappingRule // Rule that matched
+	Key     string      // Mapping key that matched
+}
+
+// resolveImage resolves an image reference (without digest) to a Chainguard image using the
+// image mappings. It is used for FROM lines, ARGs used as base images and COPY --from images,
+// so that they all match the same way.
+//
+// Exact keys are tried first: the full reference with tag, the full reference, the basename,
+// the Docker Hub variants and the normalized reference. If the mapping is just node, it matches
+// all of the following:
+//
+//	registry-1.docker.io/library/node
+//	docker.io/node
+//	docker.io/library/node
+//	index.docker.io/node
+//	index.docker.io/library/node
+//
+// If the mapping is someorg/somerepo, it matches all of the following:
+//
+//	registry-1.docker.io/someorg/somerepo
+//	docker.io/someorg/somerepo
+//	index.docker.io/someorg/somerepo
+//
+// Then patterns are tried: globs and regular expressions matching the full path, then globs
+// matching the basename. Among the patterns of the same kind, the one with the longest literal
+// prefix wins, then the longest key, then the first key in lexical order, so that the result
+// never depends on map iteration order. Before globs matching the basename, the distroless images
+// without a language runtime (static, base and cc) are mapped to the Chainguard image providing
+// the same C runtime (static, glibc-dynamic and cc-dynamic).
+func resolveImage(base, tag string, images map[string]string) ImageMapping {
+	baseFilename := path.Base(base)
+
+	match := func(key string, rule MappingRule) (ImageMapping, bool) {
+		mapped, ok := images[key]
+		if !ok {
+			return ImageMapping{}, false
+		}
+		image, mappedTag, _ := strings.Cut(mapped, ":")
+		return ImageMapping{Image: image, Tag: mappedTag, Rule: rule, Key: key}, true
+	}
+
+	// First check for exact match with full image reference including tag
+	if tag != "" {
+		if m, ok := match(base+":"+tag, MappingRuleExactTag); ok {
+			return m
+		}
+	}
+	if m, ok := match(base, MappingRuleExact); ok {
+		return m
+	}
+	if m, ok := match(baseFilename, MappingRuleBasename); ok {
+		return m
+	}
+
+	// Check if any Docker Hub variant matches a key in the mappings
+	for _, variant := range generateDockerHubVariants(base) {
+		if m, ok := match(variant, MappingRuleDockerHub); ok {
+			return m
+		}
+	}
+
+	// Normalize the base and check against simple keys, with and without the library/ prefix
+	normalizedBase := normalizeImageName(base)
+	if m, ok := match(normalizedBase, MappingRuleNormalized); ok {
+		return m
+	}
+	if simpleBase, ok := strings.CutPrefix(normalizedBase, "library/"); ok {
+		if m, ok := match(simpleBase, MappingRuleNormalized); ok {
+			return m
+		}
+	}
+
+	// Then pick the most specific pattern
+	paths := []string{base}
+	if normalizedBase != base {
+		paths = append(paths, normalizedBase)
+	}
+	var best *imagePattern
+	for key := range images {
+		pattern, ok := matchImagePattern(key, paths, baseFilename)
+		if ok && (best == nil || pattern.moreSpecific(best)) {
+			best = &pattern
+		}
+	}
+	if best != nil && best.rule != MappingRuleBasenameGlob {
+		m, _ := match(best.key, best.rule)
+		return m
+	}
+
+	// Map distroless images by variant, ahead of basename globs like static*
+	if m, ok := resolveDistroless(base); ok {
+		return m
+	}
+	if best != nil {
+		m, _ := match(best.key, best.rule)
+		return m
+	}
+
+	return ImageMapping{Image: baseFilename, Rule: MappingRuleNone}
+}
+
+// imagePattern is an image mapping key that matched as a pattern
+type imagePattern struct {
+	key    string
+	rule   MappingRule
+	prefix string // literal prefix of the pattern
+}
+
+// moreSpecific reports whether the pattern takes precedence over the other pattern
+func (p imagePattern) moreSpecific(other *imagePattern) bool {
+	if rank, otherRank := p.rule == MappingRuleBasenameGlob, other.rule == MappingRuleBasenameGlob; rank != otherRank {
+		return otherRank
+	}
+	if len(p.prefix) != len(other.prefix) {
+		return len(p.prefix) > len(other.prefix)
+	}
+	if len(p.key) != len(other.key) {
+		return len(p.key) > len(other.key)
+	}
+	return p.key < other.key
+}
+
+// matchImagePattern matches an image mapping key that is a glob pattern or a regular expression
+// against the paths of an image reference, or against its basename for globs without a slash
+func matchImagePattern(key string, paths []string, baseFilename string) (imagePattern, bool) {
+	if expr, ok := strings.CutPrefix(key, RegexMappingPrefix); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return imagePattern{}, false
+		}
+		var prefix string
+		if unanchored, err := regexp.Compile(strings.TrimPrefix(expr, "^")); err == nil {
+			prefix, _ = unanchored.LiteralPrefix()
+		}
+		for _, p := range paths {
+			if re.MatchString(p) {
+				return imagePattern{key: key, rule: MappingRuleRegex, prefix: prefix}, true
+			}
+		}
+		return imagePattern{}, false
+	}
+
+	i := strings.IndexAny(key, globChars)
+	if i < 0 {
+		return imagePattern{}, false
+	}
+	pattern := imagePattern{key: key, rule: MappingRulePathGlob, prefix: key[:i]}
+	if !strings.Contains(key, "/") {
+		pattern.rule = MappingRuleBasenameGlob
+		paths = []string{baseFilename}
+	}
+	for _, p := range paths {
+		if matched, _ := path.Match(key, p); matched {
+			return pattern, true
+		}
+	}
+	return imagePattern{}, false
+}
+
+// validateImageMappings checks that the glob and regular expression keys of the image mappings are valid
+func validateImageMappings(images map[string]string) error {
+	for key := range images {
+		if expr, ok := strings.CutPrefix(key, RegexMappingPrefix); ok {
+			if _, err := regexp.Compile(expr); err != nil {
+				return fmt.Errorf("invalid image mapping %q: %w", key, err)
+			}
+		} else if strings.ContainsAny(key, globChars) {
+			if _, err := path.Match(key, ""); err != nil {
+				return fmt.Errorf("invalid image mapping %q: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
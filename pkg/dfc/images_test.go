@@ -0,0 +1,183 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveImage(t *testing.T) {
+	images := map[string]string{
+		"node:18":                        "node:18.20",
+		"node":                           "node",
+		"ghcr.io/acme/api":               "acme-api",
+		"someorg/app":                    "app-image",
+		"docker.io/library/ubuntu":       "chainguard-base",
+		"registry.example.com/team/tool": "tool",
+		"openjdk*":                       "jdk",
+		"go*":                            "go-any",
+		"golang*":                        "go",
+		"ghcr.io/acme/*":                 "acme",
+		"ghcr.io/acme/web-*":             "acme-web",
+		"acme-*":                         "acme-basename",
+		`regex:^quay\.io/(prod|staging)/.+-worker$`: "worker",
+	}
+
+	testCases := []struct {
+		name     string
+		base     string
+		tag      string
+		expected ImageMapping
+	}{
+		{
+			name:     "exact with tag",
+			base:     "node",
+			tag:      "18",
+			expected: ImageMapping{Image: "node", Tag: "18.20", Rule: MappingRuleExactTag, Key: "node:18"},
+		},
+		{
+			name:     "exact",
+			base:     "ghcr.io/acme/api",
+			tag:      "1.2",
+			expected: ImageMapping{Image: "acme-api", Rule: MappingRuleExact, Key: "ghcr.io/acme/api"},
+		},
+		{
+			name:     "basename",
+			base:     "docker.io/library/node",
+			tag:      "20",
+			expected: ImageMapping{Image: "node", Rule: MappingRuleBasename, Key: "node"},
+		},
+		{
+			name:     "docker hub variant",
+			base:     "ubuntu",
+			expected: ImageMapping{Image: "chainguard-base", Rule: MappingRuleDockerHub, Key: "docker.io/library/ubuntu"},
+		},
+		{
+			name:     "normalized",
+			base:     "index.docker.io/someorg/app",
+			expected: ImageMapping{Image: "app-image", Rule: MappingRuleNormalized, Key: "someorg/app"},
+		},
+		{
+			name:     "basename glob",
+			base:     "openjdk-17",
+			expected: ImageMapping{Image: "jdk", Rule: MappingRuleBasenameGlob, Key: "openjdk*"},
+		},
+		{
+			name:     "longest basename glob prefix wins",
+			base:     "golang-alpine",
+			expected: ImageMapping{Image: "go", Rule: MappingRuleBasenameGlob, Key: "golang*"},
+		},
+		{
+			name:     "path glob",
+			base:     "ghcr.io/acme/billing",
+			tag:      "2.0",
+			expected: ImageMapping{Image: "acme", Rule: MappingRulePathGlob, Key: "ghcr.io/acme/*"},
+		},
+		{
+			name:     "longest path glob prefix wins",
+			base:     "ghcr.io/acme/web-frontend",
+			expected: ImageMapping{Image: "acme-web", Rule: MappingRulePathGlob, Key: "ghcr.io/acme/web-*"},
+		},
+		{
+			name:     "path glob before basename glob",
+			base:     "ghcr.io/acme/acme-cli",
+			expected: ImageMapping{Image: "acme", Rule: MappingRulePathGlob, Key: "ghcr.io/acme/*"},
+		},
+		{
+			name:     "path glob does not cross slashes",
+			base:     "ghcr.io/acme/team/acme-cli",
+			expected: ImageMapping{Image: "acme-basename", Rule: MappingRuleBasenameGlob, Key: "acme-*"},
+		},
+		{
+			name:     "regex",
+			base:     "quay.io/prod/billing-worker",
+			expected: ImageMapping{Image: "worker", Rule: MappingRuleRegex, Key: `regex:^quay\.io/(prod|staging)/.+-worker$`},
+		},
+		{
+			name:     "regex matches the full path",
+			base:     "quay.io/dev/billing-worker",
+			expected: ImageMapping{Image: "billing-worker", Rule: MappingRuleNone},
+		},
+		{
+			name:     "no match",
+			base:     "quay.io/example/unknown",
+			expected: ImageMapping{Image: "unknown", Rule: MappingRuleNone},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Map iteration order is random, so resolve several times
+			for range 20 {
+				got := resolveImage(tc.base, tc.tag, images)
+				if diff := cmp.Diff(tc.expected, got); diff != "" {
+					t.Fatalf("resolveImage(%q, %q) mismatch (-want +got):\n%s", tc.base, tc.tag, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateImageMappings(t *testing.T) {
+	for _, key := range []string{"regex:^ghcr\\.io/(acme", "ghcr.io/acme/[a-"} {
+		if err := validateImageMappings(map[string]string{key: "image"}); err == nil {
+			t.Errorf("validateImageMappings(%q) = nil, want error", key)
+		}
+	}
+	if err := validateImageMappings(map[string]string{"ghcr.io/acme/*": "acme", "regex:^quay\\.io/.+$": "quay"}); err != nil {
+		t.Errorf("validateImageMappings() = %v, want nil", err)
+	}
+}
+
+func TestArgAndFromImageResolution(t *testing.T) {
+	refs := []string{
+		"docker.io/library/node:20",
+		"index.docker.io/someorg/app:1.2",
+		"registry-1.docker.io/library/ubuntu:22.04",
+	}
+	opts := Options{
+		NoBuiltIn: true,
+		ExtraMappings: MappingsConfig{
+			Images: map[string]string{
+				"node":        "node",
+				"someorg/app": "app-image",
+				"ubuntu":      "chainguard-base",
+			},
+		},
+	}
+
+	ctx := context.Background()
+	for _, ref := range refs {
+		t.Run(ref, func(t *testing.T) {
+			fromFile, err := ParseDockerfile(ctx, []byte("FROM "+ref+"\nRUN echo hello"))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			fromConverted, err := fromFile.Convert(ctx, opts)
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+
+			argFile, err := ParseDockerfile(ctx, []byte("ARG BASE="+ref+"\nFROM ${BASE}\nRUN echo hello"))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			argConverted, err := argFile.Convert(ctx, opts)
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+
+			fromImage := fromConverted.Lines[0].Converted[len(DirectiveFrom+" "):]
+			argImage := argConverted.Lines[0].Arg.DefaultValue
+			if fromImage != argImage {
+				t.Errorf("ARG converted to %q, FROM converted to %q", argImage, fromImage)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// defaultInstallers are the installer scripts piped into a shell whose software is packaged in Wolfi,
+// used along with the installers from the mappings (the mappings take precedence for the same pattern)
+var defaultInstallers = map[string]string{
+	`^https://get\.docker\.com/?$`:                                             "docker",
+	`^https://sh\.rustup\.rs/?$`:                                               "rust",
+	`^https://(?:deb|rpm)\.nodesource\.com/setup_(\d+)\.x$`:                    "nodejs-$1",
+	`^https://(?:deb|rpm)\.nodesource\.com/setup_(?:lts|current)\.x$`:          "nodejs",
+	`^https://install\.python-poetry\.org/?$`:                                  "poetry",
+	`^https://bootstrap\.pypa\.io/get-pip\.py$`:                                "py3-pip",
+	`^https://astral\.sh/uv/(?:[\d.]+/)?install\.sh$`:                          "uv",
+	`^https://raw\.githubusercontent\.com/helm/helm/[^/]+/scripts/get-helm-3$`: "helm",
+}
+
+// installerShells are the interpreters installer scripts are piped into
+var installerShells = []string{"sh", "bash", "zsh", "dash", "python", "python3"}
+
+// installerSubstitution matches a script downloaded in a command substitution, like sh -c "$(curl -fsSL URL)"
+var installerSubstitution = regexp.MustCompile(`[$<]\((?:curl|wget)\s[^)]*?(https?://[^\s"')]+)`)
+
+// variableExpansion matches the variables expanded in a URL, like ${NODE_VERSION} or $NODE_VERSION
+var variableExpansion = regexp.MustCompile(`\$\{(\w+)\}|\$(\w+)`)
+
+// installer maps the URLs of an installer script to the packages replacing it
+type installer struct {
+	Pattern  *regexp.Regexp
+	Packages string // Space-separated packages, with $1... for the groups of the pattern; empty if there are none
+}
+
+// validateInstallers checks that the URL patterns of the installers are valid regular expressions
+func validateInstallers(installers map[string]string) error {
+	for pattern := range installers {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid installer pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// resolveInstallers returns the installers from the mappings, then the built-in ones
+func resolveInstallers(installers map[string]string) []installer {
+	var result []installer
+	for _, patterns := range []map[string]string{installers, defaultInstallers} {
+		keys := make([]string, 0, len(patterns))
+		for pattern := range patterns {
+			keys = append(keys, pattern)
+		}
+		sort.Strings(keys)
+		for _, pattern := range keys {
+			if re, err := regexp.Compile(pattern); err == nil {
+				result = append(result, installer{Pattern: re, Packages: patterns[pattern]})
+			}
+		}
+	}
+	return result
+}
+
+// matchInstaller returns the packages replacing the installer script at a URL, and whether the
+// script is known
+func matchInstaller(url string, installers []installer) ([]string, bool) {
+	for _, i := range installers {
+		match := i.Pattern.FindStringSubmatchIndex(url)
+		if match == nil {
+			continue
+		}
+		return strings.Fields(string(i.Pattern.ExpandString(nil, i.Packages, url, match))), true
+	}
+	return nil, false
+}
+
+// parseInstaller returns the URL of an installer script run by a shell part: a script downloaded
+// with curl or wget and piped into a shell (curl URL | sh), or run from a command substitution
+// (sh -c "$(curl URL)")
+func parseInstaller(part *ShellPart) (string, bool) {
+	commands := splitPipeline(part)
+	if len(commands) > 1 {
+		file, ok := parseDownload(commands[0])
+		if !ok || file.Output != "-" {
+			return "", false
+		}
+		for _, command := range commands[1:] {
+			name := command.Command
+			if name == "sudo" && len(command.Args) > 0 {
+				name = command.Args[0]
+			}
+			if slices.Contains(installerShells, name) {
+				return file.URL, true
+			}
+		}
+		return "", false
+	}
+
+	if !slices.Contains(installerShells, part.Command) {
+		return "", false
+	}
+	match := installerSubstitution.FindStringSubmatch(strings.Join(part.Args, " "))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// expandVariables expands the ARG and ENV variables with a known value in a string
+func expandVariables(s string, vars map[string]string) string {
+	return variableExpansion.ReplaceAllStringFunc(s, func(ref string) string {
+		match := variableExpansion.FindStringSubmatch(ref)
+		name := match[1] + match[2]
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return ref
+	})
+}
+
+// todoFailure returns the commands failing the build where an installer script had no replacement
+func todoFailure(url, delimiter string) []*ShellPart {
+	return []*ShellPart{
+		{Command: "echo", Args: []string{fmt.Sprintf(`"TODO: replace the installer script %s with apk packages"`, url), ">&2"}, Delimiter: "&&"},
+		{Command: "exit", Args: []string{"1"}, Delimiter: delimiter},
+	}
+}
+
+// replaceInstallers replaces the installer scripts piped into a shell (curl URL | sh), which assume
+// a Debian userland, with the packages installing the same software, or with commands failing the
+// build with a TODO when no package is known. Packages are added to the apk add of the line if
+// there is one, replacing the unversioned package of the same version stream (nodejs for nodejs-18).
+func replaceInstallers(lines []*DockerfileLine, graph *StageGraph, installers []installer) {
+	vars := stageVariables(lines)
+	for _, line := range lines {
+		if line.From != nil || !graph.Builds(line.Stage) {
+			continue
+		}
+		parts := runShellParts(line)
+		if len(parts) == 0 {
+			continue
+		}
+
+		var packages []string
+		changed := false
+		first := -1
+		newParts := make([]*ShellPart, 0, len(parts))
+		for _, part := range parts {
+			url, ok := parseInstaller(part)
+			if !ok {
+				newParts = append(newParts, cloneShellPart(part))
+				continue
+			}
+			changed = true
+			url = expandVariables(url, vars[line.Stage])
+			installed, known := matchInstaller(url, installers)
+			if !known || len(installed) == 0 {
+				newParts = append(newParts, todoFailure(url, part.Delimiter)...)
+				line.Notes = append(line.Notes, fmt.Sprintf("replaced the installer script %s with a failing TODO: no package is known to replace it", url))
+				continue
+			}
+			if first == -1 {
+				first = len(newParts)
+			}
+			for _, pkg := range installed {
+				if !slices.Contains(packages, pkg) {
+					packages = append(packages, pkg)
+				}
+			}
+			line.Notes = append(line.Notes, fmt.Sprintf("installed %s instead of running the installer script %s", strings.Join(installed, ", "), url))
+		}
+		if !changed {
+			continue
+		}
+
+		if len(packages) > 0 {
+			newParts = addInstallerPackages(newParts, packages, first)
+		}
+		for _, part := range newParts[:len(newParts)-1] {
+			if part.Delimiter == "" {
+				part.Delimiter = "&&"
+			}
+		}
+		newParts[len(newParts)-1].Delimiter = ""
+		shell := &ShellCommand{Parts: newParts}
+		line.Run.Shell.After = shell
+		line.Converted = runInstruction(line.Raw, shell)
+	}
+}
+
+// addInstallerPackages adds the packages replacing installer scripts to the first apk add of the
+// parts, or where the first installer was if there is none
+func addInstallerPackages(parts []*ShellPart, packages []string, first int) []*ShellPart {
+	for _, part := range parts {
+		if part.Command != string(ManagerApk) || !slices.Contains(part.Args, SubcommandAdd) {
+			continue
+		}
+		// Versioned packages replace the unversioned package of their version stream
+		for _, pkg := range packages {
+			if match := versionedPackage.FindStringSubmatch(pkg); match != nil {
+				part.Args = slices.DeleteFunc(part.Args, func(arg string) bool { return arg == match[1] })
+			}
+		}
+		part.Args = append(part.Args, packages...)
+		return parts
+	}
+	return replaceWithApkPackages(parts, packages, first)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReplaceInstallers(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		mappings      MappingsConfig
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "known installer",
+			raw: `FROM debian:12
+RUN curl -fsSL https://get.docker.com | sh`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache docker
+`,
+			expectedNotes: []string{"installed docker instead of running the installer script https://get.docker.com"},
+		},
+		{
+			name: "installer options and following commands",
+			raw: `FROM debian:12
+RUN curl https://sh.rustup.rs -sSf | sh -s -- -y && cargo build --release`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache rust && \
+    cargo build --release
+`,
+			expectedNotes: []string{"installed rust instead of running the installer script https://sh.rustup.rs"},
+		},
+		{
+			name: "versioned package replacing the package of the repository",
+			raw: `FROM debian:12
+ARG NODE_MAJOR=20
+RUN wget -qO- https://deb.nodesource.com/setup_${NODE_MAJOR}.x | bash - && \
+    apt-get install -y nodejs git`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+ARG NODE_MAJOR=20
+RUN apk add --no-cache git nodejs-20
+`,
+			expectedNotes: []string{"installed nodejs-20 instead of running the installer script https://deb.nodesource.com/setup_20.x"},
+		},
+		{
+			name: "unknown installer",
+			raw: `FROM debian:12
+RUN sh -c "$(curl -fsSL https://example.com/install.sh)" && echo done`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN echo "TODO: replace the installer script https://example.com/install.sh with apk packages" >&2 && \
+    exit 1 && \
+    echo done
+`,
+			expectedNotes: []string{"replaced the installer script https://example.com/install.sh with a failing TODO: no package is known to replace it"},
+		},
+		{
+			name: "installers from mappings",
+			raw: `FROM debian:12
+RUN curl -sSL https://example.com/tool/v1.2/install.sh | sudo bash && curl -fsSL https://get.docker.com | sh`,
+			mappings: MappingsConfig{Installers: map[string]string{
+				`^https://example\.com/tool/v(\d+)\.(\d+)/install\.sh$`: "example-tool-$1.$2 example-tool-plugins",
+				`^https://get\.docker\.com/?$`:                          "",
+			}},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache example-tool-1.2 example-tool-plugins && \
+    echo "TODO: replace the installer script https://get.docker.com with apk packages" >&2 && \
+    exit 1
+`,
+			expectedNotes: []string{
+				"installed example-tool-1.2, example-tool-plugins instead of running the installer script https://example.com/tool/v1.2/install.sh",
+				"replaced the installer script https://get.docker.com with a failing TODO: no package is known to replace it",
+			},
+		},
+		{
+			name: "downloads piped into other commands",
+			raw: `FROM debian:12
+RUN curl -fsSL https://example.com/key.gpg | gpg --dearmor -o /usr/share/keyrings/example.gpg`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+RUN curl -fsSL https://example.com/key.gpg | gpg --dearmor -o /usr/share/keyrings/example.gpg`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{ExtraMappings: tc.mappings})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From == nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateInstallers(t *testing.T) {
+	if err := validateInstallers(map[string]string{`^https://example\.com/(install`: "tool"}); err == nil {
+		t.Error("validateInstallers() = nil, want error for an invalid pattern")
+	}
+	if err := validateInstallers(defaultInstallers); err != nil {
+		t.Errorf("validateInstallers(defaultInstallers) = %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"maps"
 
 	"github.com/chainguard-dev/clog"
 	"gopkg.in/yaml.v3"
@@ -61,40 +62,47 @@ func defaultGetDefaultMappings(ctx context.Context, update bool) (MappingsConfig
 // MergeMappings merges the base and overlay mappings
 // Any values in the overlay take precedence over the base
 func MergeMappings(base, overlay MappingsConfig) MappingsConfig {
-	result := MappingsConfig{
-		Images:   make(map[string]string),
-		Packages: make(PackageMap),
-	}
-
-	// Copy base images
-	for k, v := range base.Images {
-		result.Images[k] = v
-	}
-
-	// Overlay with extra images
-	for k, v := range overlay.Images {
-		result.Images[k] = v
+	return MappingsConfig{
+		Images: mergeMap(base.Images, overlay.Images),
+		// Overlay packages replace the base packages of the same distro and name
+		Packages: mergeNestedMap(base.Packages, overlay.Packages),
+		// Overlay tag rules, contents and metadata replace the base ones of the same image
+		Tags:     mergeMap(base.Tags, overlay.Tags),
+		Contents: mergeMap(base.Contents, overlay.Contents),
+		Metadata: mergeMap(base.Metadata, overlay.Metadata),
+		// Overlay path rewrites replace the base path rewrites of the same image and path
+		Paths: mergeNestedMap(base.Paths, overlay.Paths),
+		// Overlay downloads and installers replace the base ones of the same URL pattern
+		Downloads:  mergeMap(base.Downloads, overlay.Downloads),
+		Installers: mergeMap(base.Installers, overlay.Installers),
+		// Overlay tools replace the base tools of the same ecosystem and name
+		Tools: mergeNestedMap(base.Tools, overlay.Tools),
 	}
+}
 
-	// Copy base packages for each distro
-	for distro, packages := range base.Packages {
-		if result.Packages[distro] == nil {
-			result.Packages[distro] = make(map[string][]string)
-		}
-		for pkg, mappings := range packages {
-			result.Packages[distro][pkg] = mappings
-		}
-	}
+// mergeMap returns the entries of base and overlay, those of overlay replacing the entries of
+// base with the same key
+func mergeMap[M ~map[K]V, K comparable, V any](base, overlay M) M {
+	result := make(M, len(base)+len(overlay))
+	maps.Copy(result, base)
+	maps.Copy(result, overlay)
+	return result
+}
 
-	// Overlay with extra packages
-	for distro, packages := range overlay.Packages {
-		if result.Packages[distro] == nil {
-			result.Packages[distro] = make(map[string][]string)
-		}
-		for pkg, mappings := range packages {
-			result.Packages[distro][pkg] = mappings
+// mergeNestedMap merges maps of maps, merging the inner maps with the same key with mergeMap
+func mergeNestedMap[M ~map[K]N, N ~map[K2]V, K, K2 comparable, V any](base, overlay M) M {
+	result := make(M, len(base)+len(overlay))
+	for _, m := range []M{base, overlay} {
+		for key := range m {
+			result[key] = mergeMap(base[key], overlay[key])
 		}
 	}
-
 	return result
 }
+
+// isEmpty reports whether the mappings have no entries
+func (m MappingsConfig) isEmpty() bool {
+	return len(m.Images) == 0 && len(m.Packages) == 0 && len(m.Tags) == 0 && len(m.Contents) == 0 &&
+		len(m.Paths) == 0 && len(m.Metadata) == 0 && len(m.Downloads) == 0 && len(m.Tools) == 0 &&
+		len(m.Installers) == 0
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeMappings(t *testing.T) {
+	base := MappingsConfig{
+		Images:   map[string]string{"node": "node", "python": "python"},
+		Packages: PackageMap{"debian": {"curl": {"curl"}, "nano": {"nano"}}},
+		Contents: map[string][]string{"node": {"nodejs", "npm"}},
+		Paths:    map[string]PathMap{"python": {"/usr/local/bin/python": "/usr/bin/python", "/usr/local/bin/pip": "/usr/bin/pip"}},
+		Tools:    map[string]ToolMap{EcosystemPip: {"awscli": "aws-cli", "ansible": "ansible"}},
+	}
+	overlay := MappingsConfig{
+		Images:    map[string]string{"python": "python-custom"},
+		Packages:  PackageMap{"debian": {"nano": {"vim"}}, "alpine": {"bash": {"bash"}}},
+		Contents:  map[string][]string{"node": {"nodejs"}},
+		Paths:     map[string]PathMap{"python": {"/usr/local/bin/pip": "/opt/bin/pip"}},
+		Tools:     map[string]ToolMap{EcosystemPip: {"ansible": ""}},
+		Downloads: map[string]string{`^https://example\.com/tool$`: "tool"},
+	}
+	expected := MappingsConfig{
+		Images:   map[string]string{"node": "node", "python": "python-custom"},
+		Packages: PackageMap{"debian": {"curl": {"curl"}, "nano": {"vim"}}, "alpine": {"bash": {"bash"}}},
+		Tags:     map[string][]TagRule{},
+		// Contents replace the contents of the same image
+		Contents: map[string][]string{"node": {"nodejs"}},
+		// Paths and tools replace the paths and tools of the same image or ecosystem one by one
+		Paths:      map[string]PathMap{"python": {"/usr/local/bin/python": "/usr/bin/python", "/usr/local/bin/pip": "/opt/bin/pip"}},
+		Metadata:   map[string]ImageMetadata{},
+		Downloads:  map[string]string{`^https://example\.com/tool$`: "tool"},
+		Tools:      map[string]ToolMap{EcosystemPip: {"awscli": "aws-cli", "ansible": ""}},
+		Installers: map[string]string{},
+	}
+	if diff := cmp.Diff(expected, MergeMappings(base, overlay)); diff != "" {
+		t.Errorf("MergeMappings() mismatch (-want +got):\n%s", diff)
+	}
+
+	if !(MappingsConfig{}).isEmpty() {
+		t.Error("isEmpty() = false for empty mappings")
+	}
+	if overlay.isEmpty() {
+		t.Error("isEmpty() = true for mappings with entries")
+	}
+}
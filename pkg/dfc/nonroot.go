@@ -0,0 +1,386 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NonRootUID is the UID (and GID) of the nonroot user of Chainguard images
+const NonRootUID = "65532"
+
+// Commands changing the owner of files
+const (
+	CommandChown = "chown"
+	CommandChgrp = "chgrp"
+)
+
+// firstAppUID is the first UID given to regular users by Debian and most distributions
+const firstAppUID = 1000
+
+// chownFlag matches the --chown option of COPY and ADD, with the owner
+var chownFlag = regexp.MustCompile(`--chown=(\S+)`)
+
+// userCommandValueFlags are the options of the user and group commands that take a value, along
+// with whether the value is the UID, the primary group or the GID
+var userCommandValueFlags = map[string]map[string]string{
+	CommandAddUser:  {"-u": "uid", "--uid": "uid", "-G": "group", "--ingroup": "group", "-h": "home", "--home": "home", "-s": "", "--shell": "", "-g": "", "--gecos": "", "-k": ""},
+	CommandUserAdd:  {"-u": "uid", "--uid": "uid", "-g": "group", "--gid": "group", "-G": "", "--groups": "", "-d": "home", "--home-dir": "home", "-s": "", "--shell": "", "-c": "", "--comment": "", "-k": "", "-e": "", "-f": "", "-p": ""},
+	CommandAddGroup: {"-g": "gid", "--gid": "gid"},
+	CommandGroupAdd: {"-g": "gid", "--gid": "gid", "-K": "", "-p": ""},
+}
+
+// userCommand is a parsed adduser, useradd, addgroup or groupadd command
+type userCommand struct {
+	Name  string // User or group created
+	ID    string // UID or GID
+	Group string // Primary group of the user
+	Extra string // Second positional argument: adduser/addgroup USER GROUP adds a user to a group
+	Home  string // Home directory created for the user, empty if none is created
+}
+
+// parseUserCommand parses the arguments of a command creating a user or group
+func parseUserCommand(part *ShellPart) (userCommand, bool) {
+	flags, ok := userCommandValueFlags[part.Command]
+	if !ok {
+		return userCommand{}, false
+	}
+	var cmd userCommand
+	var positional []string
+	var home string
+	// adduser creates the home directory unless told not to, useradd only when told to
+	createHome := part.Command == CommandAddUser
+	for i := 0; i < len(part.Args); i++ {
+		arg := part.Args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		kind, takesValue := flags[name]
+		switch {
+		case takesValue && !hasValue && i+1 < len(part.Args):
+			value = part.Args[i+1]
+			i++
+		case takesValue && hasValue:
+		case strings.HasPrefix(arg, "-"):
+			switch arg {
+			case "-m", "--create-home":
+				createHome = part.Command == CommandUserAdd
+			case "-M", "-H", "--no-create-home", "-S", "--system", "-r":
+				createHome = false
+			}
+			continue
+		default:
+			positional = append(positional, arg)
+			continue
+		}
+		switch kind {
+		case "uid", "gid":
+			cmd.ID = value
+		case "group":
+			cmd.Group = value
+		case "home":
+			home = value
+		}
+	}
+	if len(positional) == 0 {
+		return userCommand{}, false
+	}
+	cmd.Name = positional[0]
+	if len(positional) > 1 {
+		cmd.Extra = positional[1]
+	}
+	if createHome && cmd.Extra == "" {
+		cmd.Home = cmp.Or(home, path.Join("/home", cmd.Name))
+	}
+	return cmd, true
+}
+
+// appUsers are the users and groups collapsed onto the nonroot user
+type appUsers struct {
+	Users  map[string]bool   // Names and UIDs
+	Groups map[string]bool   // Names and GIDs
+	Homes  map[string]string // Home directories created for the users, by name
+}
+
+// findAppUsers finds the app users: the users created in RUN lines that USER directives switch
+// to (by name or UID), and UIDs of regular users used by USER directives. Their groups are the
+// groups created with the same name or used as their primary group, whose GID is the UID of
+// the user when no primary group is given. Their home directories are those the original
+// commands created, as useradd only creates one with -m, unlike the adduser it is converted to.
+func findAppUsers(lines []*DockerfileLine) appUsers {
+	var users, groups []userCommand
+	var userValues []string
+	homes := make(map[string]string)
+	for _, line := range lines {
+		if user, ok := userDirectiveValue(lineText(line)); ok {
+			userValues = append(userValues, user)
+			continue
+		}
+		if line.Run != nil && line.Run.Shell != nil && line.Run.Shell.Before != nil {
+			for _, part := range line.Run.Shell.Before.Parts {
+				for _, command := range splitPipeline(part) {
+					if cmd, ok := parseUserCommand(command); ok && (command.Command == CommandAddUser || command.Command == CommandUserAdd) {
+						homes[cmd.Name] = cmd.Home
+					}
+				}
+			}
+		}
+		for _, part := range runShellParts(line) {
+			for _, command := range splitPipeline(part) {
+				cmd, ok := parseUserCommand(command)
+				if !ok || cmd.Extra != "" || cmd.Name == DefaultUser || cmd.Name == DefaultNonRootUser {
+					continue
+				}
+				if command.Command == CommandAddUser || command.Command == CommandUserAdd {
+					users = append(users, cmd)
+				} else {
+					groups = append(groups, cmd)
+				}
+			}
+		}
+	}
+
+	app := appUsers{Users: make(map[string]bool), Groups: make(map[string]bool), Homes: make(map[string]string)}
+	for _, value := range userValues {
+		user, group, _ := strings.Cut(value, ":")
+		for _, cmd := range users {
+			if user == cmd.Name || (cmd.ID != "" && user == cmd.ID) {
+				app.Users[cmd.Name] = true
+				if homes[cmd.Name] != "" {
+					app.Homes[cmd.Name] = homes[cmd.Name]
+				}
+				if cmd.ID != "" {
+					app.Users[cmd.ID] = true
+				}
+				app.Groups[cmd.Name] = true
+				if cmd.Group != "" {
+					app.Groups[cmd.Group] = true
+				} else if cmd.ID != "" {
+					app.Groups[cmd.ID] = true
+				}
+			}
+		}
+		if uid, err := strconv.Atoi(user); err == nil && uid >= firstAppUID && user != NonRootUID && uid != 65534 {
+			app.Users[user] = true
+			if group == "" {
+				group = user
+			}
+			app.Groups[group] = true
+		}
+	}
+	for _, cmd := range groups {
+		if app.Groups[cmd.Name] && cmd.ID != "" {
+			app.Groups[cmd.ID] = true
+		}
+	}
+	return app
+}
+
+// runShellParts returns the parts of the shell command of a RUN line, as converted so far
+func runShellParts(line *DockerfileLine) []*ShellPart {
+	if line.Run == nil || line.Run.Shell == nil {
+		return nil
+	}
+	if line.Converted == "" {
+		if line.Run.Shell.Before == nil {
+			return nil
+		}
+		return line.Run.Shell.Before.Parts
+	}
+
+	// The converted instruction has the latest changes, like rewritten paths
+	text := strings.TrimSpace(line.Converted)
+	prefix := DirectiveRun + " "
+	if len(text) < len(prefix) || !strings.EqualFold(text[:len(prefix)], prefix) {
+		return nil
+	}
+	shell := ParseMultilineShell(text[len(prefix):])
+	if shell == nil {
+		return nil
+	}
+	return shell.Parts
+}
+
+// nonroot returns the nonroot equivalent of a user or group, keeping numeric IDs numeric
+func nonroot(id string) string {
+	if _, err := strconv.Atoi(id); err == nil {
+		return NonRootUID
+	}
+	return DefaultNonRootUser
+}
+
+// rewriteOwner rewrites the app users and groups of an owner like user, user:group or :group
+func (a appUsers) rewriteOwner(owner string) string {
+	user, group, hasGroup := strings.Cut(owner, ":")
+	if a.Users[user] {
+		user = nonroot(user)
+	}
+	if hasGroup {
+		if a.Groups[group] {
+			group = nonroot(group)
+		}
+		return user + ":" + group
+	}
+	return user
+}
+
+// useNonRootUser collapses the app users onto the nonroot user of Chainguard images: their
+// creation is removed (their home directory is created for nonroot instead), and USER, COPY/ADD
+// --chown and chown/chgrp commands use nonroot instead, in every stage, so files copied between
+// stages keep the same owner. RUN lines left without commands are dropped, their comments and
+// notes moving to the next line. It also returns the stages that still need USER root for their
+// other RUN lines, because the dropped lines were their converted ones.
+func useNonRootUser(lines []*DockerfileLine, graph *StageGraph) ([]*DockerfileLine, map[int]bool) {
+	app := findAppUsers(lines)
+	if len(app.Users) == 0 {
+		return lines, nil
+	}
+
+	empty := make(map[*DockerfileLine]bool)
+	dropped := make(map[int]bool)
+	for _, line := range lines {
+		if line.From != nil || !graph.Builds(line.Stage) {
+			continue
+		}
+		text := lineText(line)
+		directive, args := cutInstruction(text)
+		switch directive {
+		case DirectiveUser:
+			if user := app.rewriteOwner(args); user != args {
+				line.Converted = DirectiveUser + " " + user
+				line.Notes = append(line.Notes, fmt.Sprintf("using %s instead of %s: Chainguard images provide the nonroot user (%s)", user, args, NonRootUID))
+			}
+		case DirectiveCopy, "ADD":
+			// Keep the line continuations of multi-line instructions
+			rewritten := chownFlag.ReplaceAllStringFunc(text, func(flag string) string {
+				return "--chown=" + app.rewriteOwner(strings.TrimPrefix(flag, "--chown="))
+			})
+			if rewritten != text {
+				line.Converted = rewritten
+				line.Notes = append(line.Notes, fmt.Sprintf("using the nonroot user (%s) for --chown", NonRootUID))
+			}
+		case DirectiveRun:
+			if rewriteUserCommands(line, app) {
+				// Nothing left to run
+				empty[line] = true
+				dropped[line.Stage] = true
+			}
+		}
+	}
+
+	result := dropLines(lines, empty)
+	rootStages := make(map[int]bool)
+	for _, line := range result {
+		if line.Run != nil && dropped[line.Stage] {
+			rootStages[line.Stage] = true
+		}
+	}
+	return result, rootStages
+}
+
+// rewriteUserCommands removes the creation of app users and groups from a RUN line, and makes
+// chown/chgrp and commands adding app users to groups use nonroot instead. The home directories
+// of the removed users are created and owned by nonroot. It returns whether no command is left
+// to run.
+func rewriteUserCommands(line *DockerfileLine, app appUsers) bool {
+	parts := runShellParts(line)
+	if len(parts) == 0 {
+		return false
+	}
+
+	var removed, homes []string
+	changed := false
+	newParts := make([]*ShellPart, 0, len(parts))
+	for _, part := range parts {
+		commands := splitPipeline(part)
+		if len(commands) == 1 {
+			if cmd, ok := parseUserCommand(part); ok {
+				isUser := part.Command == CommandAddUser || part.Command == CommandUserAdd
+				if cmd.Extra == "" && ((isUser && app.Users[cmd.Name]) || (!isUser && app.Groups[cmd.Name])) {
+					removed = append(removed, part.Command+" "+cmd.Name)
+					changed = true
+					if home := app.Homes[cmd.Name]; isUser && home != "" {
+						owner := DefaultNonRootUser + ":" + DefaultNonRootUser
+						newParts = append(newParts,
+							&ShellPart{Command: "mkdir", Args: []string{"-p", home}, Delimiter: "&&"},
+							&ShellPart{Command: CommandChown, Args: []string{owner, home}, Delimiter: part.Delimiter})
+						homes = append(homes, home)
+					}
+					continue
+				}
+			}
+		}
+
+		partChanged := false
+		for j, command := range commands {
+			if rewritten := rewriteOwnerCommand(command, app); rewritten != nil {
+				commands[j] = rewritten
+				partChanged = true
+			}
+		}
+		if partChanged {
+			part = joinPipeline(commands, part.Delimiter)
+			changed = true
+		}
+		newParts = append(newParts, part)
+	}
+	if !changed {
+		return false
+	}
+	if len(removed) > 0 {
+		line.Notes = append(line.Notes, fmt.Sprintf("removed %s: Chainguard images provide the nonroot user (%s)", strings.Join(removed, ", "), NonRootUID))
+	}
+	if len(homes) > 0 {
+		line.Notes = append(line.Notes, fmt.Sprintf("created %s for the nonroot user (%s)", strings.Join(homes, ", "), NonRootUID))
+	}
+	if len(newParts) == 0 {
+		return true
+	}
+
+	// The last remaining part ends the command
+	last := cloneShellPart(newParts[len(newParts)-1])
+	last.Delimiter = ""
+	newParts[len(newParts)-1] = last
+	shell := &ShellCommand{Parts: newParts}
+	line.Run.Shell.After = shell
+	line.Converted = runInstruction(line.Raw, shell)
+	return false
+}
+
+// rewriteOwnerCommand returns a chown, chgrp, adduser USER GROUP or addgroup USER GROUP command
+// using nonroot instead of the app users and groups, or nil if there is nothing to rewrite
+func rewriteOwnerCommand(command *ShellPart, app appUsers) *ShellPart {
+	result := cloneShellPart(command)
+	switch command.Command {
+	case CommandChown, CommandChgrp:
+		i := slices.IndexFunc(result.Args, func(arg string) bool { return !strings.HasPrefix(arg, "-") })
+		if i == -1 {
+			return nil
+		}
+		if command.Command == CommandChown {
+			result.Args[i] = app.rewriteOwner(result.Args[i])
+		} else if app.Groups[result.Args[i]] {
+			result.Args[i] = nonroot(result.Args[i])
+		}
+	case CommandAddUser, CommandAddGroup:
+		cmd, ok := parseUserCommand(command)
+		if !ok || cmd.Extra == "" || !app.Users[cmd.Name] {
+			return nil
+		}
+		i := slices.Index(result.Args, cmd.Name)
+		result.Args[i] = DefaultNonRootUser
+	default:
+		return nil
+	}
+	if slices.Equal(result.Args, command.Args) {
+		return nil
+	}
+	return result
+}
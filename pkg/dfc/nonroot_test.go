@@ -0,0 +1,184 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUseNonRootUser(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		nonRoot       bool
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "disabled by default",
+			raw: `FROM python:3.12
+RUN useradd -u 1000 app
+USER app`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN adduser --uid 1000 app
+USER app`,
+		},
+		{
+			name: "app user created and used",
+			raw: `FROM python:3.12
+RUN groupadd -g 1000 app && useradd -u 1000 -g app -m app
+COPY --chown=app:app . /app
+RUN pip install -r requirements.txt && chown -R app:app /app
+USER app`,
+			nonRoot: true,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN mkdir -p /home/app && \
+    chown nonroot:nonroot /home/app
+COPY --chown=nonroot:nonroot . /app
+RUN pip install -r requirements.txt && \
+    chown -R nonroot:nonroot /app
+USER nonroot
+`,
+			expectedNotes: []string{
+				"removed addgroup app, adduser app: Chainguard images provide the nonroot user (65532)",
+				"created /home/app for the nonroot user (65532)",
+				"using the nonroot user (65532) for --chown",
+				"using nonroot instead of app: Chainguard images provide the nonroot user (65532)",
+			},
+		},
+		{
+			name: "numeric IDs across stages",
+			raw: `FROM golang:1.22 AS build
+COPY --chown=1000:1000 . /src
+RUN go build -o /app ./cmd/app
+
+FROM debian:12
+RUN useradd -u 1000 app && mkdir /data
+COPY --from=build --chown=1000:1000 /app /app
+USER 1000:1000`,
+			nonRoot: true,
+			expected: `FROM cgr.dev/ORG/go:1.22-dev AS build
+COPY --chown=65532:65532 . /src
+RUN go build -o /app ./cmd/app
+
+FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN mkdir /data
+COPY --from=build --chown=65532:65532 /app /app
+USER 65532:65532
+`,
+			expectedNotes: []string{
+				"using the nonroot user (65532) for --chown",
+				"removed adduser app: Chainguard images provide the nonroot user (65532)",
+				"using the nonroot user (65532) for --chown",
+				"using 65532:65532 instead of 1000:1000: Chainguard images provide the nonroot user (65532)",
+			},
+		},
+		{
+			name: "implicit primary group",
+			raw: `FROM node:20
+RUN useradd -u 1000 app
+COPY --chown=app \
+    . /app
+RUN chown -R 1000:1000 /app
+USER 1000`,
+			nonRoot: true,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+COPY --chown=nonroot \
+    . /app
+RUN chown -R 65532:65532 /app
+USER 65532
+`,
+			expectedNotes: []string{
+				"removed adduser app: Chainguard images provide the nonroot user (65532)",
+				"using the nonroot user (65532) for --chown",
+				"using 65532 instead of 1000: Chainguard images provide the nonroot user (65532)",
+			},
+		},
+		{
+			name: "nothing left to run as root",
+			raw: `FROM python:3.12
+# Create the app user
+RUN useradd app
+WORKDIR /app
+USER app`,
+			nonRoot: true,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+# Create the app user
+WORKDIR /app
+USER nonroot
+`,
+			expectedNotes: []string{
+				"removed adduser app: Chainguard images provide the nonroot user (65532)",
+				"using nonroot instead of app: Chainguard images provide the nonroot user (65532)",
+			},
+		},
+		{
+			name: "root kept for the other RUN lines",
+			raw: `FROM node:20
+RUN useradd app
+WORKDIR /srv/app
+RUN npm ci
+USER app`,
+			nonRoot: true,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+WORKDIR /srv/app
+RUN npm ci
+USER nonroot
+`,
+			expectedNotes: []string{
+				"removed adduser app: Chainguard images provide the nonroot user (65532)",
+				"using nonroot instead of app: Chainguard images provide the nonroot user (65532)",
+			},
+		},
+		{
+			name: "users not switched to are kept",
+			raw: `FROM debian:12
+RUN useradd -r svc && chown svc /var/lib/svc
+USER 0`,
+			nonRoot: true,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN adduser --system svc && \
+    chown svc /var/lib/svc
+USER 0`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{NonRoot: tc.nonRoot})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From == nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
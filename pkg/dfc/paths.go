@@ -0,0 +1,173 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// AllImagesPaths is the key of the path rewrites that apply to every image
+const AllImagesPaths = "*"
+
+// PathMap maps paths of the original images to their equivalent in a Chainguard image. Keys are
+// literal paths, matched as whole paths or path prefixes, or regular expressions prefixed with
+// regex:, whose groups can be used in the rewritten path ($1). Rewritten paths can use ${version},
+// the version of the converted tag.
+type PathMap map[string]string
+
+// defaultPathRewrites are the paths that differ in common Chainguard images, which are rewritten
+// along with the paths from the mappings (the mappings take precedence for the same path)
+var defaultPathRewrites = map[string]PathMap{
+	AllImagesPaths: {
+		"/usr/lib/x86_64-linux-gnu":  "/usr/lib",
+		"/usr/lib/aarch64-linux-gnu": "/usr/lib",
+	},
+	"python": {
+		"/usr/local/bin/python":                              "/usr/bin/python",
+		"/usr/local/bin/python3":                             "/usr/bin/python3",
+		"/usr/local/bin/pip":                                 "/usr/bin/pip",
+		"/usr/local/bin/pip3":                                "/usr/bin/pip3",
+		RegexMappingPrefix + `/usr/local/lib/python(3\.\d+)`: "/usr/lib/python$1",
+	},
+	"node": {
+		"/usr/local/bin/node": "/usr/bin/node",
+		"/usr/local/bin/npm":  "/usr/bin/npm",
+	},
+	"go": {
+		"/usr/local/go/bin": "/usr/bin",
+		"/usr/local/go":     "/usr/lib/go",
+	},
+	"jdk": {
+		RegexMappingPrefix + `/usr/lib/jvm/java-(\d+)-openjdk-(?:amd64|arm64)`: "/usr/lib/jvm/java-$1-openjdk",
+		"/opt/java/openjdk": "/usr/lib/jvm/java-" + VersionPlaceholder + "-openjdk",
+	},
+	"jre": {
+		RegexMappingPrefix + `/usr/lib/jvm/java-(\d+)-openjdk-(?:amd64|arm64)`: "/usr/lib/jvm/java-$1-openjdk",
+		"/opt/java/openjdk": "/usr/lib/jvm/java-" + VersionPlaceholder + "-openjdk",
+	},
+}
+
+// pathRewrite is a compiled path rewrite
+type pathRewrite struct {
+	Key     string
+	Pattern *regexp.Regexp
+	Replace string // Expansion template for Pattern
+}
+
+// compilePathRewrite compiles the key of a path rewrite into a regular expression
+func compilePathRewrite(key, replace string) (pathRewrite, error) {
+	if expr, ok := strings.CutPrefix(key, RegexMappingPrefix); ok {
+		re, err := regexp.Compile(expr)
+		return pathRewrite{Key: key, Pattern: re, Replace: replace}, err
+	}
+	return pathRewrite{
+		Key:     key,
+		Pattern: regexp.MustCompile(regexp.QuoteMeta(key)),
+		Replace: strings.ReplaceAll(replace, "$", "$$"),
+	}, nil
+}
+
+// validatePathRewrites checks that the regular expressions of the path rewrites are valid
+func validatePathRewrites(paths map[string]PathMap) error {
+	for image, rewrites := range paths {
+		for key, replace := range rewrites {
+			if _, err := compilePathRewrite(key, replace); err != nil {
+				return fmt.Errorf("invalid path rewrite %q for %s: %w", key, image, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolvePathRewrites returns the path rewrites of a converted image: the rewrites of the image,
+// then the rewrites for all images, each from the mappings over the defaults, longest keys first
+func resolvePathRewrites(image, tag string, paths map[string]PathMap) []pathRewrite {
+	var rewrites []pathRewrite
+	for _, name := range []string{image, AllImagesPaths} {
+		var group []pathRewrite
+		for key, replace := range mergeMap(defaultPathRewrites[name], paths[name]) {
+			replace, ok := expandVersion(replace, tag)
+			if !ok {
+				continue
+			}
+			if rewrite, err := compilePathRewrite(key, replace); err == nil {
+				group = append(group, rewrite)
+			}
+		}
+		slices.SortFunc(group, func(a, b pathRewrite) int {
+			return cmp.Or(cmp.Compare(len(b.Key), len(a.Key)), cmp.Compare(a.Key, b.Key))
+		})
+		rewrites = append(rewrites, group...)
+	}
+	return rewrites
+}
+
+// isPathChar reports whether a character can be part of a path component
+func isPathChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// rewrite applies the path rewrite to the paths of a line, and returns the rewritten paths. Matches
+// must be whole paths or path prefixes: /usr/local/go matches /usr/local/go/bin but not
+// /usr/local/gopath or /opt/usr/local/go.
+func (r pathRewrite) rewrite(text string) (string, []string) {
+	var b strings.Builder
+	var rewritten []string
+	last := 0
+	for _, match := range r.Pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		if (start > 0 && (isPathChar(text[start-1]) || text[start-1] == '/')) || (end < len(text) && isPathChar(text[end])) {
+			continue
+		}
+		replaced := string(r.Pattern.ExpandString(nil, r.Replace, text, match))
+		b.WriteString(text[last:start])
+		b.WriteString(replaced)
+		last = end
+		if change := fmt.Sprintf("%s to %s", text[start:end], replaced); !slices.Contains(rewritten, change) {
+			rewritten = append(rewritten, change)
+		}
+	}
+	if last == 0 {
+		return text, nil
+	}
+	b.WriteString(text[last:])
+	return b.String(), rewritten
+}
+
+// rewritePaths rewrites the paths that differ in the converted image of each stage, in every
+// instruction but FROM, and adds a note for each rewrite
+func rewritePaths(lines []*DockerfileLine, stageImages map[int]convertedImage, paths map[string]PathMap) {
+	rewrites := make(map[int][]pathRewrite)
+	for stage, image := range stageImages {
+		rewrites[stage] = resolvePathRewrites(image.Mapping.Image, image.Tag, paths)
+	}
+
+	for _, line := range lines {
+		if line.From != nil || len(rewrites[line.Stage]) == 0 {
+			continue
+		}
+		text := line.Converted
+		if text == "" {
+			text = line.Raw
+		}
+		changed := false
+		for _, rewrite := range rewrites[line.Stage] {
+			var rewritten []string
+			text, rewritten = rewrite.rewrite(text)
+			for _, r := range rewritten {
+				line.Notes = append(line.Notes, fmt.Sprintf("rewrote path %s for the %s image", r, stageImages[line.Stage].Mapping.Image))
+				changed = true
+			}
+		}
+		if changed {
+			line.Converted = text
+		}
+	}
+}
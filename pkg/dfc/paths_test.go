@@ -0,0 +1,131 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPathRewrite(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      string
+		replace  string
+		text     string
+		expected string
+	}{
+		{
+			name:     "whole path",
+			key:      "/usr/local/bin/python",
+			replace:  "/usr/bin/python",
+			text:     `ENTRYPOINT ["/usr/local/bin/python", "app.py"]`,
+			expected: `ENTRYPOINT ["/usr/bin/python", "app.py"]`,
+		},
+		{
+			name:     "path prefix",
+			key:      "/usr/local/go",
+			replace:  "/usr/lib/go",
+			text:     "ENV PATH=$PATH:/usr/local/go/bin GOROOT=/usr/local/go",
+			expected: "ENV PATH=$PATH:/usr/lib/go/bin GOROOT=/usr/lib/go",
+		},
+		{
+			name:     "longer component",
+			key:      "/usr/local/go",
+			replace:  "/usr/lib/go",
+			text:     "ENV GOPATH=/usr/local/gopath",
+			expected: "ENV GOPATH=/usr/local/gopath",
+		},
+		{
+			name:     "nested path",
+			key:      "/usr/local/go",
+			replace:  "/usr/lib/go",
+			text:     "COPY go /opt/usr/local/go",
+			expected: "COPY go /opt/usr/local/go",
+		},
+		{
+			name:     "regex",
+			key:      `regex:/usr/lib/jvm/java-(\d+)-openjdk-(?:amd64|arm64)`,
+			replace:  "/usr/lib/jvm/java-$1-openjdk",
+			text:     "ENV JAVA_HOME=/usr/lib/jvm/java-17-openjdk-amd64",
+			expected: "ENV JAVA_HOME=/usr/lib/jvm/java-17-openjdk",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rewrite, err := compilePathRewrite(tc.key, tc.replace)
+			if err != nil {
+				t.Fatalf("compilePathRewrite() error = %v", err)
+			}
+			if got, _ := rewrite.rewrite(tc.text); got != tc.expected {
+				t.Errorf("rewrite() = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+
+	if err := validatePathRewrites(map[string]PathMap{"python": {"regex:/usr/(lib": "/usr/lib"}}); err == nil {
+		t.Errorf("Expected error for invalid path rewrite")
+	}
+}
+
+func TestPathRewriteConversion(t *testing.T) {
+	raw := `FROM python:3.12-slim AS build
+ENV LD_LIBRARY_PATH=/usr/lib/x86_64-linux-gnu
+RUN /usr/local/bin/pip install -r requirements.txt
+
+FROM nginx:1.25
+COPY --from=build /usr/local/lib/python3.12/site-packages /site-packages
+COPY nginx.conf /etc/nginx/conf.d/default.conf
+
+FROM debian:bookworm
+COPY app /usr/local/bin/python`
+	expected := `FROM cgr.dev/ORG/python:3.12-dev AS build
+ENV LD_LIBRARY_PATH=/usr/lib
+RUN /usr/bin/pip install -r requirements.txt
+
+FROM cgr.dev/ORG/nginx:1.25
+COPY --from=build /usr/local/lib/python3.12/site-packages /site-packages
+COPY nginx.conf /etc/nginx/http.d/default.conf
+
+FROM cgr.dev/ORG/chainguard-base:latest
+COPY app /usr/local/bin/python`
+	expectedNotes := []string{
+		"rewrote path /usr/lib/x86_64-linux-gnu to /usr/lib for the python image",
+		"rewrote path /usr/local/bin/pip to /usr/bin/pip for the python image",
+		"rewrote path /etc/nginx/conf.d to /etc/nginx/http.d for the nginx image",
+	}
+
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{
+		ExtraMappings: MappingsConfig{Paths: map[string]PathMap{"nginx": {"/etc/nginx/conf.d": "/etc/nginx/http.d"}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(expected, converted.String()); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+
+	var notes []string
+	for _, line := range converted.Lines {
+		for _, note := range line.Notes {
+			if strings.HasPrefix(note, "rewrote path ") {
+				notes = append(notes, note)
+			}
+		}
+	}
+	if diff := cmp.Diff(expectedNotes, notes); diff != "" {
+		t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+	}
+}
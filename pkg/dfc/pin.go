@@ -0,0 +1,244 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Registry related constants
+const (
+	DigestHeader   = "Docker-Content-Digest"
+	ManifestAccept = "application/vnd.oci.image.index.v1+json, application/vnd.oci.image.manifest.v1+json, " +
+		"application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// DigestResolver resolves a converted image reference (e.g. cgr.dev/chainguard/node:22) to the
+// digest of its manifest (e.g. sha256:...), used to pin converted images
+type DigestResolver func(ctx context.Context, ref string) (string, error)
+
+// NewDigestResolver returns a DigestResolver for a source, which is either a local OCI image layout
+// directory (or a directory of OCI image layouts) or an OCI distribution registry endpoint, like
+// https://cgr.dev (https is assumed when there is no scheme). Registry requests are sent with the
+// user agent, or DefaultUserAgent if it is empty.
+func NewDigestResolver(source, userAgent string) (DigestResolver, error) {
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		return NewOCILayoutDigestResolver(source)
+	}
+	if !strings.Contains(source, "://") {
+		source = "https://" + source
+	}
+	if _, err := url.Parse(source); err != nil {
+		return nil, fmt.Errorf("parsing registry endpoint: %w", err)
+	}
+	return NewRegistryDigestResolver(source, nil, userAgent), nil
+}
+
+// NewOCILayoutDigestResolver returns a DigestResolver looking up the digests of the manifests of an
+// OCI image layout directory, or of a directory of OCI image layouts (one per image), by image name
+// and tag. The registry and organization of the references are ignored.
+func NewOCILayoutDigestResolver(dir string) (DigestResolver, error) {
+	digests := make(map[string]string)
+	err := walkOCILayouts(dir, func(layout string, manifest ociDescriptor) {
+		if image, tag := ociReference(layout, manifest.Annotations); image != "" && manifest.Digest != "" {
+			digests[image+":"+tag] = manifest.Digest
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(_ context.Context, ref string) (string, error) {
+		repo, tag := splitReference(ref)
+		if digest, ok := digests[path.Base(repo)+":"+tag]; ok {
+			return digest, nil
+		}
+		return "", fmt.Errorf("%s:%s not found in %s", path.Base(repo), tag, dir)
+	}, nil
+}
+
+// NewRegistryDigestResolver returns a DigestResolver fetching the digests of manifests from an OCI
+// distribution registry endpoint (e.g. https://cgr.dev or http://localhost:5000). The repository is
+// the path of the reference without its registry domain. Anonymous bearer tokens are requested when
+// the registry asks for them. If client is nil, http.DefaultClient is used, and if userAgent is empty,
+// DefaultUserAgent is used.
+func NewRegistryDigestResolver(endpoint string, client *http.Client, userAgent string) DigestResolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	// The same image is often used by several lines
+	var mu sync.Mutex
+	digests := make(map[string]string)
+
+	return func(ctx context.Context, ref string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if digest, ok := digests[ref]; ok {
+			return digest, nil
+		}
+
+		repo, tag := splitReference(ref)
+		manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", endpoint, repositoryPath(repo), tag)
+		digest, err := fetchManifestDigest(ctx, client, userAgent, manifestURL)
+		if err != nil {
+			return "", err
+		}
+		digests[ref] = digest
+		return digest, nil
+	}
+}
+
+// splitReference splits an image reference into its repository and tag (latest if there is none),
+// dropping any digest
+func splitReference(ref string) (repo, tag string) {
+	ref, _, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// repositoryPath returns the repository without its registry domain, e.g. chainguard/node for
+// cgr.dev/chainguard/node
+func repositoryPath(repo string) string {
+	domain, rest, ok := strings.Cut(repo, "/")
+	if ok && (strings.ContainsAny(domain, ".:") || domain == "localhost") {
+		return rest
+	}
+	return repo
+}
+
+// fetchManifestDigest fetches a manifest and returns its digest, from the Docker-Content-Digest
+// header or from the content. If the registry requires a bearer token, an anonymous token is
+// requested and the manifest fetched again.
+func fetchManifestDigest(ctx context.Context, client *http.Client, userAgent, manifestURL string) (string, error) {
+	resp, err := getManifest(ctx, client, userAgent, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		token, err := fetchToken(ctx, client, userAgent, challenge)
+		if err != nil {
+			return "", err
+		}
+		if resp, err = getManifest(ctx, client, userAgent, manifestURL, token); err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: unexpected status code: %d", manifestURL, resp.StatusCode)
+	}
+	if digest := resp.Header.Get(DigestHeader); digest != "" {
+		return digest, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading manifest: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(hash[:]), nil
+}
+
+// getManifest sends a GET request for a manifest, with a bearer token if there is one
+func getManifest(ctx context.Context, client *http.Client, userAgent, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", ManifestAccept)
+	req.Header.Set("User-Agent", userAgent)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", manifestURL, err)
+	}
+	return resp, nil
+}
+
+// challengeParam matches the parameters of a WWW-Authenticate challenge
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// fetchToken requests an anonymous token from the realm of a bearer challenge
+func fetchToken(ctx context.Context, client *http.Client, userAgent, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	query := url.Values{}
+	var realm string
+	for _, match := range challengeParam.FindAllStringSubmatch(params, -1) {
+		if match[1] == "realm" {
+			realm = match[2]
+		} else {
+			query.Set(match[1], match[2])
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("authentication challenge %q has no realm", challenge)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching token: unexpected status code: %d", resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("parsing token: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// pinImageReference appends the digest of a converted image reference, keeping the tag for
+// readability. References with ARG variables or a digest already are left as they are.
+func pinImageReference(ctx context.Context, ref string, resolver DigestResolver) (string, error) {
+	if strings.ContainsAny(ref, "$@") {
+		return ref, nil
+	}
+	digest, err := resolver(ctx, ref)
+	if err != nil {
+		return ref, err
+	}
+	return ref + "@" + digest, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	testNodeDigest   = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	testPythonDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func TestPinWithOCILayout(t *testing.T) {
+	layout := t.TempDir()
+	index := fmt.Sprintf(`{"schemaVersion": 2, "manifests": [
+		{"digest": %q, "annotations": {"org.opencontainers.image.ref.name": "cgr.dev/chainguard/node:22-dev"}},
+		{"digest": %q, "annotations": {"org.opencontainers.image.ref.name": "cgr.dev/chainguard/python:3.12"}}
+	]}`, testNodeDigest, testPythonDigest)
+	if err := os.WriteFile(filepath.Join(layout, OCILayoutIndex), []byte(index), 0600); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := NewOCILayoutDigestResolver(layout)
+	if err != nil {
+		t.Fatalf("NewOCILayoutDigestResolver() error = %v", err)
+	}
+
+	raw := `ARG BASE=python:3.12-slim
+FROM node:22 AS build
+RUN npm ci
+
+FROM ${BASE}
+COPY --from=build /app /app
+COPY --from=nginx:1.25 /etc/nginx/nginx.conf /etc/nginx/`
+	expected := `ARG BASE=cgr.dev/ORG/python:3.12@` + testPythonDigest + `
+FROM cgr.dev/ORG/node:22-dev@` + testNodeDigest + ` AS build
+RUN npm ci
+
+FROM ${BASE}
+COPY --from=build /app /app
+COPY --from=cgr.dev/ORG/nginx:1.25 /etc/nginx/nginx.conf /etc/nginx/
+`
+
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{DigestResolver: resolver})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(expected, converted.String()); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+
+	expectedNote := "could not pin cgr.dev/ORG/nginx:1.25 to a digest: nginx:1.25 not found in " + layout
+	if diff := cmp.Diff([]string{"mapped COPY image nginx:1.25 to cgr.dev/ORG/nginx:1.25", expectedNote}, converted.Lines[len(converted.Lines)-1].Notes); diff != "" {
+		t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+	}
+}
+
+func TestPinWithRegistry(t *testing.T) {
+	pythonManifest := `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []}`
+	pythonHash := sha256.Sum256([]byte(pythonManifest))
+	pythonDigest := "sha256:" + hex.EncodeToString(pythonHash[:])
+
+	requests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "dfc/test-version" {
+			http.Error(w, "unexpected user agent "+got, http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/token":
+			if r.URL.Query().Get("scope") != "repository:ORG/node:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"token": "anonymous"}`)
+		case "/v2/ORG/node/manifests/22-dev":
+			requests++
+			if r.Header.Get("Authorization") != "Bearer anonymous" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:ORG/node:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set(DigestHeader, testNodeDigest)
+			fmt.Fprint(w, `{}`)
+		case "/v2/ORG/python/manifests/3.12":
+			fmt.Fprint(w, pythonManifest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	resolver := NewRegistryDigestResolver(server.URL, server.Client(), "dfc/test-version")
+
+	raw := `FROM node:22 AS build
+RUN npm ci
+
+FROM node:22 AS test
+RUN npm test
+
+FROM python:3.12
+COPY --from=build /app /app`
+	expected := `FROM cgr.dev/ORG/node:22-dev@` + testNodeDigest + ` AS build
+RUN npm ci
+
+FROM cgr.dev/ORG/node:22-dev@` + testNodeDigest + ` AS test
+RUN npm test
+
+FROM cgr.dev/ORG/python:3.12@` + pythonDigest + `
+COPY --from=build /app /app`
+
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{DigestResolver: resolver})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(expected, converted.String()); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests for the node manifest (challenge and retry), got %d", requests)
+	}
+
+	if _, err := resolver(ctx, "cgr.dev/ORG/go:1.24"); err == nil {
+		t.Errorf("Expected error for missing manifest")
+	}
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Runtime stage related constants
+const (
+	BuilderStageAlias = "builder"
+)
+
+// runtimeDirectives are the instructions copied from the build stage to the runtime stage
+// as they are, in their original order
+var runtimeDirectives = []string{"ARG", "ENV", "LABEL", "EXPOSE", "VOLUME", "STOPSIGNAL", "HEALTHCHECK"}
+
+// systemInstaller describes a command installing files outside of the working directory
+type systemInstaller struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// systemInstallers are the commands that prevent splitting a Dockerfile, since the files they
+// install would not be copied to the runtime stage (see also pipInstallsOutside)
+var systemInstallers = []systemInstaller{
+	{Name: "npm install --global", Pattern: regexp.MustCompile(`\bnpm\s+(install|i)\b[^;&|]*\s(-g|--global)\b`)},
+	{Name: "gem install", Pattern: regexp.MustCompile(`\bgem\s+install\b`)},
+	{Name: "go install", Pattern: regexp.MustCompile(`\bgo\s+install\b`)},
+	{Name: "cargo install", Pattern: regexp.MustCompile(`\bcargo\s+install\b`)},
+	{Name: "make install", Pattern: regexp.MustCompile(`\bmake\b[^;&|]*\sinstall\b`)},
+}
+
+// buildPackages are the packages only needed to build the application, which are not missed by
+// the runtime stage. The -dev packages are not: what is built against them links to their libraries.
+var buildPackages = []string{
+	"autoconf", "automake", "bash", "binutils", "build-base", "cmake", "coreutils", "curl", "findutils",
+	"g++", "gcc", "git", "grep", "libtool", "make", "patch", "pkgconf", "sed", "shadow", "tar", "unzip",
+	"wget", "xz",
+}
+
+// splitRuntime rewrites a single-stage Dockerfile into a build stage using the -dev image and a
+// runtime stage using the minimal image, which copies the working directories, copied files and
+// files run by ENTRYPOINT and CMD from the build stage. When this cannot be done safely the
+// Dockerfile is left as is, with a note explaining why.
+func splitRuntime(ctx context.Context, lines []*DockerfileLine, graph *StageGraph, stagePackages map[int][]string, opts Options) []*DockerfileLine {
+	fromLine := splitRuntimeFromLine(lines, graph)
+	if fromLine == nil {
+		return lines
+	}
+
+	var reason string
+	var copied []string
+	var runtimeLines, workdirs, runs []string
+	var workdir, user, entrypoint, cmd string
+	var venvOnPath bool
+	users := make(map[string]userCommand)
+	groups := make(map[string]string)
+	for _, line := range lines {
+		if line.Stage != fromLine.Stage || line == fromLine || reason != "" {
+			continue
+		}
+		// Converted lines have the changes of the earlier passes, like rewritten paths
+		text := strings.TrimSpace(lineText(line))
+		if directive, _ := cutInstruction(line.Raw); directive == DirectiveRun {
+			runs = append(runs, text)
+			if name, ok := systemInstall(line, text, workdirs, venvOnPath); ok {
+				reason = fmt.Sprintf("%s installs files outside of the working directory", name)
+			}
+			createdUsers(line, users, groups)
+			continue
+		}
+		directive, args := cutInstruction(text)
+		if args == "" {
+			continue
+		}
+
+		switch {
+		case slices.Contains(runtimeDirectives, directive):
+			if directive == "HEALTHCHECK" && !isExecForm(strings.TrimSpace(strings.TrimPrefix(args, "CMD"))) && args != "NONE" {
+				reason = "the HEALTHCHECK uses shell form, which needs a shell"
+				continue
+			}
+			if directive == "ENV" && setsVirtualEnv(args, workdirs) {
+				venvOnPath = true
+			}
+			runtimeLines = append(runtimeLines, text)
+		case directive == "WORKDIR":
+			if !path.IsAbs(args) && workdir != "" {
+				args = path.Join(workdir, args)
+			}
+			if !path.IsAbs(args) || strings.Contains(args, "$") {
+				reason = fmt.Sprintf("WORKDIR %s is not an absolute path", args)
+				continue
+			}
+			workdir = args
+			workdirs = append(workdirs, args)
+		case directive == DirectiveUser:
+			user = args
+		case directive == DirectiveEntrypoint, directive == DirectiveCmd:
+			if !isExecForm(args) {
+				reason = fmt.Sprintf("%s uses shell form, which needs a shell", directive)
+				continue
+			}
+			if directive == DirectiveEntrypoint {
+				entrypoint = text
+			} else {
+				cmd = text
+			}
+		case directive == DirectiveCopy, directive == "ADD":
+			// Files copied outside of the working directory are copied to the runtime stage as well
+			fields := strings.Fields(args)
+			if dest := fields[len(fields)-1]; path.IsAbs(dest) && !strings.Contains(dest, "$") {
+				copied = append(copied, dest)
+			}
+		}
+	}
+
+	// Files run by ENTRYPOINT and CMD which the build stage creates are needed at runtime
+	var executables []string
+	for _, instruction := range []string{entrypoint, cmd} {
+		var command []string
+		if _, args := cutInstruction(instruction); json.Unmarshal([]byte(args), &command) != nil || len(command) == 0 {
+			continue
+		}
+		executables = append(executables, path.Base(command[0]))
+		for _, arg := range command {
+			if path.IsAbs(arg) && !strings.Contains(arg, "$") && !withinAny(arg, workdirs) &&
+				slices.ContainsFunc(runs, func(run string) bool { return strings.Contains(run, arg) }) {
+				copied = append(copied, arg)
+			}
+		}
+	}
+
+	if packages := runtimePackages(stagePackages[fromLine.Stage], executables); reason == "" && len(packages) > 0 {
+		reason = fmt.Sprintf("packages installed in the build stage (%s) would be missing at runtime", strings.Join(packages, ", "))
+	}
+	if reason == "" && workdir == "" {
+		reason = "there is no WORKDIR to copy to the runtime stage"
+	}
+	runUser, ok := runtimeUser(user, users, groups)
+	if reason == "" && !ok {
+		reason = fmt.Sprintf("user %s is only created in the build stage, without a UID to run the runtime stage as", user)
+	}
+
+	// The runtime stage uses the same image without -dev
+	buildInstruction, _, _ := strings.Cut(fromLine.Converted, "\n")
+	buildRef := strings.Fields(buildInstruction)[1]
+	runtime := convertImageReference(ctx, fromLine.From, false, linkNeed{}, opts)
+	runtimeRef := runtime.Ref
+	if reason == "" && runtimeRef == buildRef {
+		reason = fmt.Sprintf("%s has no separate runtime image", buildRef)
+	}
+
+	if reason != "" {
+		fromLine.Notes = append(fromLine.Notes, "not split into build and runtime stages: "+reason)
+		return lines
+	}
+
+	// Name the build stage so it can be copied from
+	alias := fromLine.From.Alias
+	if alias == "" {
+		alias = BuilderStageAlias
+		fromLine.From.Alias = alias
+		fromLine.Converted = strings.Replace(fromLine.Converted, buildInstruction, buildInstruction+" "+KeywordAs+" "+alias, 1)
+	}
+
+	// Copy the working directories, and the files outside of them
+	var paths []string
+	for _, p := range append(workdirs, copied...) {
+		if !withinAny(p, paths) {
+			paths = append(paths, p)
+		}
+	}
+
+	stage := graph.Final + 1
+	runtimeFrom := parseFromDetails(runtimeRef)
+	notes := append([]string{fmt.Sprintf("split into build stage %s and runtime stage %s", alias, runtimeRef)}, runtime.Notes...)
+	if runUser != user {
+		notes = append(notes, fmt.Sprintf("the runtime stage runs as %s: user %s is only created in the build stage", runUser, user))
+	}
+	newLines := []*DockerfileLine{{
+		Converted: DirectiveFrom + " " + runtimeRef,
+		Extra:     "\n",
+		Stage:     stage,
+		From:      runtimeFrom,
+		Notes:     notes,
+	}}
+	addLine := func(converted string) {
+		newLines = append(newLines, &DockerfileLine{Converted: converted, Stage: stage})
+	}
+	for _, line := range runtimeLines {
+		addLine(line)
+	}
+	chown := ""
+	if runUser != "" {
+		chown = "--chown=" + runUser + " "
+	}
+	for _, p := range paths {
+		addLine(fmt.Sprintf("%s --from=%s %s%s %s", DirectiveCopy, alias, chown, p, p))
+	}
+	addLine("WORKDIR " + workdir)
+	if runUser != "" {
+		addLine(DirectiveUser + " " + runUser)
+	}
+	if entrypoint != "" {
+		addLine(entrypoint)
+	}
+	if cmd != "" {
+		addLine(cmd)
+	}
+
+	return append(lines, newLines...)
+}
+
+// withinAny checks if a path is one of the directories or inside one of them
+func withinAny(p string, dirs []string) bool {
+	return slices.ContainsFunc(dirs, func(dir string) bool {
+		return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+	})
+}
+
+// resolvePath returns the absolute path of a path relative to the working directory
+func resolvePath(p string, workdirs []string) string {
+	if path.IsAbs(p) || len(workdirs) == 0 {
+		return path.Clean(p)
+	}
+	return path.Join(workdirs[len(workdirs)-1], p)
+}
+
+// systemInstall returns the name of the command of a RUN line installing files outside of the
+// working directories, if any
+func systemInstall(line *DockerfileLine, text string, workdirs []string, venvOnPath bool) (string, bool) {
+	for _, installer := range systemInstallers {
+		if installer.Pattern.MatchString(text) {
+			return installer.Name, true
+		}
+	}
+	if pipInstallsOutside(runShellParts(line), workdirs, venvOnPath) {
+		return "pip install", true
+	}
+	return "", false
+}
+
+// pipInstallsOutside checks if pip installs packages outside of the working directories, rather
+// than into a virtual environment or target directory inside them
+func pipInstallsOutside(parts []*ShellPart, workdirs []string, venvOnPath bool) bool {
+	active := venvOnPath
+	for _, part := range parts {
+		for _, command := range splitPipeline(part) {
+			args := command.Args
+			switch {
+			case (command.Command == "." || command.Command == "source") && len(args) > 0:
+				// Activating a virtual environment
+				if strings.HasSuffix(args[0], "/bin/activate") {
+					active = withinAny(resolvePath(args[0], workdirs), workdirs)
+				}
+				continue
+			case pipCommand.MatchString(path.Base(command.Command)):
+			case strings.HasPrefix(path.Base(command.Command), "python") && len(args) > 1 && args[0] == "-m" && pipCommand.MatchString(args[1]):
+				args = args[2:]
+			default:
+				continue
+			}
+			if !slices.Contains(args, "install") {
+				continue
+			}
+			if slices.Contains(args, "--user") {
+				return true
+			}
+
+			// Where packages are installed: a target directory, the virtual environment of the
+			// pip used, or the active one
+			inside := active
+			if strings.Contains(command.Command, "/") {
+				inside = withinAny(resolvePath(command.Command, workdirs), workdirs)
+			}
+			for i, arg := range args {
+				name, value, hasValue := strings.Cut(arg, "=")
+				if name != "-t" && name != "--target" && name != "--prefix" && name != "--root" {
+					continue
+				}
+				if !hasValue && i+1 < len(args) {
+					value = args[i+1]
+				}
+				inside = withinAny(resolvePath(value, workdirs), workdirs)
+			}
+			if !inside {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setsVirtualEnv checks if an ENV instruction activates a virtual environment inside the working
+// directories, by setting VIRTUAL_ENV or adding its bin directory to PATH
+func setsVirtualEnv(args string, workdirs []string) bool {
+	fields := strings.Fields(args)
+	if len(fields) == 2 && !strings.Contains(fields[0], "=") {
+		fields = []string{fields[0] + "=" + fields[1]}
+	}
+	for _, field := range fields {
+		name, value, _ := strings.Cut(field, "=")
+		value = strings.Trim(value, `"'`)
+		switch name {
+		case "VIRTUAL_ENV":
+			if withinAny(value, workdirs) {
+				return true
+			}
+		case "PATH":
+			for _, dir := range strings.Split(value, ":") {
+				if strings.HasSuffix(dir, "/bin") && withinAny(dir, workdirs) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// createdUsers records the users and groups created by a RUN line, by name
+func createdUsers(line *DockerfileLine, users map[string]userCommand, groups map[string]string) {
+	for _, part := range runShellParts(line) {
+		for _, command := range splitPipeline(part) {
+			cmd, ok := parseUserCommand(command)
+			if !ok || cmd.Extra != "" {
+				continue
+			}
+			if command.Command == CommandAddUser || command.Command == CommandUserAdd {
+				users[cmd.Name] = cmd
+			} else {
+				groups[cmd.Name] = cmd.ID
+			}
+		}
+	}
+}
+
+// runtimeUser returns the user the runtime stage runs as. Users and groups created in the build
+// stage do not exist in the runtime image, so they are replaced with their UID and GID (the GID
+// of the implicit primary group of a user is its UID). It returns false when an ID is unknown.
+func runtimeUser(user string, users map[string]userCommand, groups map[string]string) (string, bool) {
+	name, group, hasGroup := strings.Cut(user, ":")
+	uid := name
+	if cmd, ok := users[name]; ok {
+		if cmd.ID == "" {
+			return "", false
+		}
+		uid = cmd.ID
+		if !hasGroup {
+			group, hasGroup = cmd.Group, true
+			if group == "" {
+				group = cmd.Name
+			}
+		}
+		if group == cmd.Name && groups[group] == "" {
+			return uid + ":" + uid, true
+		}
+	}
+	if !hasGroup {
+		return uid, true
+	}
+	if gid, ok := groups[group]; ok {
+		if gid == "" {
+			return "", false
+		}
+		group = gid
+	}
+	return uid + ":" + group, true
+}
+
+// runtimePackages returns the packages installed in the build stage that the runtime stage needs:
+// the packages not only needed to build, and the packages of the executables of ENTRYPOINT and CMD
+func runtimePackages(packages, executables []string) []string {
+	var needed []string
+	for _, pkg := range packages {
+		name, _, _ := parseApkVersion(pkg)
+		if (!slices.Contains(buildPackages, name) || slices.Contains(executables, name)) && !slices.Contains(needed, name) {
+			needed = append(needed, name)
+		}
+	}
+	return needed
+}
+
+// splitRuntimeFromLine returns the FROM line of the stage to split, or nil (noting why) when
+// the Dockerfile cannot be split
+func splitRuntimeFromLine(lines []*DockerfileLine, graph *StageGraph) *DockerfileLine {
+	var fromLine *DockerfileLine
+	for _, line := range lines {
+		if line.From != nil {
+			fromLine = line
+			break
+		}
+	}
+	switch {
+	case fromLine == nil:
+		return nil
+	case len(graph.Stages) > 1:
+		fromLine.Notes = append(fromLine.Notes, "not split into build and runtime stages: the Dockerfile already has multiple stages")
+		return nil
+	case fromLine.Converted == "":
+		fromLine.Notes = append(fromLine.Notes, "not split into build and runtime stages: the base image is not converted")
+		return nil
+	case !graph.Stage(fromLine.Stage).HasRun:
+		// Nothing to build, the minimal image is used already
+		return nil
+	}
+	return fromLine
+}
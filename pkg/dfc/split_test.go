@@ -0,0 +1,228 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitRuntime(t *testing.T) {
+	testCases := []struct {
+		name         string
+		raw          string
+		expected     string
+		expectedNote string
+	}{
+		{
+			name: "node application",
+			raw: `FROM node:20
+ENV NODE_ENV=production
+WORKDIR /app
+COPY package.json package-lock.json ./
+RUN npm ci
+COPY . .
+COPY config.json /etc/app/config.json
+EXPOSE 3000
+USER node
+CMD ["node", "server.js"]`,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS builder
+ENV NODE_ENV=production
+WORKDIR /app
+COPY package.json package-lock.json ./
+RUN npm ci
+COPY . .
+COPY config.json /etc/app/config.json
+EXPOSE 3000
+USER node
+ENTRYPOINT []
+CMD ["node", "server.js"]
+
+FROM cgr.dev/ORG/node:20
+ENV NODE_ENV=production
+EXPOSE 3000
+COPY --from=builder --chown=node /app /app
+COPY --from=builder --chown=node /etc/app/config.json /etc/app/config.json
+WORKDIR /app
+USER node
+ENTRYPOINT []
+CMD ["node", "server.js"]
+`,
+			expectedNote: "split into build stage builder and runtime stage cgr.dev/ORG/node:20",
+		},
+		{
+			name: "packages installed",
+			raw: `FROM python:3.12
+WORKDIR /app
+RUN apt-get update && apt-get install -y libpq5
+COPY . .
+CMD ["python", "app.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+WORKDIR /app
+RUN apk add --no-cache libpq
+COPY . .
+ENTRYPOINT []
+CMD ["python", "app.py"]
+`,
+			expectedNote: "not split into build and runtime stages: packages installed in the build stage (libpq) would be missing at runtime",
+		},
+		{
+			name: "build packages, users and binaries",
+			raw: `FROM golang:1.24
+WORKDIR /src
+RUN apt-get update && apt-get install -y git build-essential
+RUN useradd -u 1001 app
+COPY . .
+RUN go build -o /usr/local/bin/server ./cmd/server
+USER app
+ENTRYPOINT ["/usr/local/bin/server"]`,
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS builder
+USER root
+WORKDIR /src
+RUN apk add --no-cache build-base git
+RUN adduser --uid 1001 app
+COPY . .
+RUN go build -o /usr/local/bin/server ./cmd/server
+USER app
+ENTRYPOINT ["/usr/local/bin/server"]
+
+FROM cgr.dev/ORG/go:1.24
+COPY --from=builder --chown=1001:1001 /src /src
+COPY --from=builder --chown=1001:1001 /usr/local/bin/server /usr/local/bin/server
+WORKDIR /src
+USER 1001:1001
+ENTRYPOINT ["/usr/local/bin/server"]
+`,
+			expectedNote: "the runtime stage runs as 1001:1001: user app is only created in the build stage",
+		},
+		{
+			name: "converted lines",
+			raw: `FROM python:3.12
+ENV PYTHONPATH=/usr/local/lib/python3.12/site-packages
+WORKDIR /app
+RUN python -m venv /app/venv && . /app/venv/bin/activate && pip install -r requirements.txt
+CMD ["/usr/local/bin/python", "app.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev AS builder
+ENV PYTHONPATH=/usr/lib/python3.12/site-packages
+WORKDIR /app
+RUN python -m venv /app/venv && . /app/venv/bin/activate && pip install -r requirements.txt
+ENTRYPOINT []
+CMD ["/usr/bin/python", "app.py"]
+
+FROM cgr.dev/ORG/python:3.12
+ENV PYTHONPATH=/usr/lib/python3.12/site-packages
+COPY --from=builder /app /app
+WORKDIR /app
+ENTRYPOINT []
+CMD ["/usr/bin/python", "app.py"]
+`,
+			expectedNote: "split into build stage builder and runtime stage cgr.dev/ORG/python:3.12",
+		},
+		{
+			name: "libraries of -dev packages",
+			raw: `FROM python:3.12
+WORKDIR /app
+RUN apt-get update && apt-get install -y libpq-dev gcc
+RUN python -m venv /app/venv && /app/venv/bin/pip install psycopg2
+CMD ["/app/venv/bin/python", "app.py"]`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+WORKDIR /app
+RUN apk add --no-cache gcc postgresql-dev
+RUN python -m venv /app/venv && /app/venv/bin/pip install psycopg2
+ENTRYPOINT []
+CMD ["/app/venv/bin/python", "app.py"]
+`,
+			expectedNote: "not split into build and runtime stages: packages installed in the build stage (postgresql-dev) would be missing at runtime",
+		},
+		{
+			name: "user without UID",
+			raw: `FROM node:20
+WORKDIR /app
+RUN useradd app
+USER app
+CMD ["node", "server.js"]`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+WORKDIR /app
+RUN adduser app
+USER app
+ENTRYPOINT []
+CMD ["node", "server.js"]
+`,
+			expectedNote: "not split into build and runtime stages: user app is only created in the build stage, without a UID to run the runtime stage as",
+		},
+		{
+			name: "shell form CMD",
+			raw: `FROM node:20
+WORKDIR /app
+RUN npm ci
+CMD node server.js`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+WORKDIR /app
+RUN npm ci
+ENTRYPOINT []
+CMD node server.js
+`,
+			expectedNote: "not split into build and runtime stages: CMD uses shell form, which needs a shell",
+		},
+		{
+			name: "system wide install",
+			raw: `FROM python:3.12
+WORKDIR /app
+RUN pip install flask`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+WORKDIR /app
+RUN pip install flask`,
+			expectedNote: "not split into build and runtime stages: pip install installs files outside of the working directory",
+		},
+		{
+			name: "multi-stage",
+			raw: `FROM golang:1.24 AS build
+RUN go build -o /app
+
+FROM golang:1.24
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS build
+RUN go build -o /app
+
+FROM cgr.dev/ORG/go:1.24
+COPY --from=build /app /app`,
+			expectedNote: "not split into build and runtime stages: the Dockerfile already has multiple stages",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{SplitRuntime: true})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From != nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if !slices.Contains(notes, tc.expectedNote) {
+				t.Errorf("expected note %q, got %q", tc.expectedNote, notes)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Other Dockerfile directives referencing stages
+const (
+	DirectiveCopy = "COPY"
+	FlagFrom      = "--from"
+	FlagMount     = "--mount"
+)
+
+// Stage describes a build stage of a Dockerfile
+type Stage struct {
+	Number   int      `json:"number"`             // Stage number, starting at 1 like DockerfileLine.Stage
+	Alias    string   `json:"alias,omitempty"`    // Name given with FROM ... AS
+	Base     string   `json:"base,omitempty"`     // Image reference of the FROM line
+	Parent   int      `json:"parent,omitempty"`   // Stage this stage is built on, 0 when built on an image
+	CopyFrom []int    `json:"copyFrom,omitempty"` // Stages referenced by COPY --from and RUN --mount=from
+	External []string `json:"external,omitempty"` // Images referenced by COPY --from and RUN --mount=from
+	HasRun   bool     `json:"hasRun,omitempty"`   // Whether the stage contains RUN instructions
+}
+
+// Name returns the alias of the stage, or stage<N> for stages without one, N being the index
+// used to reference the stage with --from or --target (the stage number minus one)
+func (s *Stage) Name() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return fmt.Sprintf("stage%d", s.Number-1)
+}
+
+// StageGraph describes the build stages of a Dockerfile and how they depend on each other
+type StageGraph struct {
+	Stages []*Stage `json:"stages"`
+	Final  int      `json:"final,omitempty"`  // The stage built by default, i.e. the last one
+	Target int      `json:"target,omitempty"` // The stage selected to be built (like docker build --target), 0 for the default
+}
+
+// Stages returns the stage graph of the Dockerfile
+func (d *Dockerfile) Stages() *StageGraph {
+	graph := &StageGraph{}
+
+	var current *Stage
+	for _, line := range d.Lines {
+		if line.From != nil {
+			current = &Stage{
+				Number: line.Stage,
+				Alias:  line.From.Alias,
+				Base:   line.From.Orig,
+				Parent: line.From.Parent,
+			}
+			graph.Stages = append(graph.Stages, current)
+			graph.Final = current.Number
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		directive, refs := stageReferences(line.Raw)
+		if directive == DirectiveRun {
+			current.HasRun = true
+		}
+		for _, ref := range refs {
+			if stage := graph.referencedStage(ref, current.Number); stage != nil {
+				if !slices.Contains(current.CopyFrom, stage.Number) {
+					current.CopyFrom = append(current.CopyFrom, stage.Number)
+				}
+			} else if !slices.Contains(current.External, ref) {
+				current.External = append(current.External, ref)
+			}
+		}
+	}
+
+	return graph
+}
+
+// SetTarget selects the stage to build by alias or index, like docker build --target
+func (g *StageGraph) SetTarget(ref string) error {
+	stage := g.Lookup(ref)
+	if stage == nil {
+		return fmt.Errorf("target stage %q not found", ref)
+	}
+	g.Target = stage.Number
+	return nil
+}
+
+// BuildStage returns the stage that is built: the target if one was selected, the final stage otherwise
+func (g *StageGraph) BuildStage() int {
+	if g.Target > 0 {
+		return g.Target
+	}
+	return g.Final
+}
+
+// Builds checks if a stage is needed to build the target. Without a target all stages are built,
+// as is everything before the first FROM.
+func (g *StageGraph) Builds(number int) bool {
+	return g.Target == 0 || number == 0 || g.Reachable(g.Target)[number]
+}
+
+// Stage returns the stage with the given number
+func (g *StageGraph) Stage(number int) *Stage {
+	for _, stage := range g.Stages {
+		if stage.Number == number {
+			return stage
+		}
+	}
+	return nil
+}
+
+// Lookup returns the stage referenced by an alias or by its index as used by
+// COPY --from=0 (which counts from 0), or nil when the reference is an image
+func (g *StageGraph) Lookup(ref string) *Stage {
+	for _, stage := range g.Stages {
+		if stage.Alias != "" && strings.EqualFold(stage.Alias, ref) {
+			return stage
+		}
+	}
+	if index, err := strconv.Atoi(ref); err == nil && index >= 0 && index < len(g.Stages) {
+		return g.Stages[index]
+	}
+	return nil
+}
+
+// referencedStage returns the stage referenced with --from in a stage, or nil when the reference
+// is an image. Only earlier stages can be referenced.
+func (g *StageGraph) referencedStage(ref string, from int) *Stage {
+	if stage := g.Lookup(ref); stage != nil && stage.Number < from {
+		return stage
+	}
+	return nil
+}
+
+// Root returns the stage at the start of the FROM-parent chain of a stage
+func (g *StageGraph) Root(number int) int {
+	for {
+		stage := g.Stage(number)
+		if stage == nil || stage.Parent == 0 {
+			return number
+		}
+		number = stage.Parent
+	}
+}
+
+// Descendants returns the stages transitively built on a stage through FROM-parent chains
+func (g *StageGraph) Descendants(number int) []int {
+	var descendants []int
+	for _, stage := range g.Stages {
+		if stage.Number != number && g.builtOn(stage.Number, number) {
+			descendants = append(descendants, stage.Number)
+		}
+	}
+	return descendants
+}
+
+// builtOn checks if the FROM-parent chain of a stage includes another stage
+func (g *StageGraph) builtOn(number int, ancestor int) bool {
+	for stage := g.Stage(number); stage != nil && stage.Parent > 0; stage = g.Stage(stage.Parent) {
+		if stage.Parent == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// Dependencies returns the stages a stage directly depends on, through FROM or --from
+func (g *StageGraph) Dependencies(number int) []int {
+	stage := g.Stage(number)
+	if stage == nil {
+		return nil
+	}
+	var deps []int
+	if stage.Parent > 0 {
+		deps = append(deps, stage.Parent)
+	}
+	for _, dep := range stage.CopyFrom {
+		if !slices.Contains(deps, dep) {
+			deps = append(deps, dep)
+		}
+	}
+	slices.Sort(deps)
+	return deps
+}
+
+// Reachable returns the stage and all stages it transitively depends on
+func (g *StageGraph) Reachable(number int) map[int]bool {
+	reachable := make(map[int]bool)
+	pending := []int{number}
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[next] || g.Stage(next) == nil {
+			continue
+		}
+		reachable[next] = true
+		pending = append(pending, g.Dependencies(next)...)
+	}
+	return reachable
+}
+
+// stagesWithRun returns the built stages containing RUN instructions, either themselves or in
+// a stage built on them: RUN lines of descendants run on the image of the stage at the
+// root of their FROM-parent chain, so it needs the -dev variant as well
+func (g *StageGraph) stagesWithRun() map[int]bool {
+	stagesWithRun := make(map[int]bool)
+	for _, stage := range g.Stages {
+		if !g.Builds(stage.Number) {
+			continue
+		}
+		if stage.HasRun {
+			stagesWithRun[stage.Number] = true
+			continue
+		}
+		for _, descendant := range g.Descendants(stage.Number) {
+			if g.Builds(descendant) && g.Stage(descendant).HasRun {
+				stagesWithRun[stage.Number] = true
+				break
+			}
+		}
+	}
+	return stagesWithRun
+}
+
+// stageReferences returns the directive of an instruction and the stages or images it
+// references with COPY --from=<ref> or RUN --mount=...,from=<ref>
+func stageReferences(raw string) (string, []string) {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	directive := strings.ToUpper(fields[0])
+	if directive != DirectiveCopy && directive != DirectiveRun {
+		return directive, nil
+	}
+
+	var refs []string
+	for _, field := range fields[1:] {
+		if field == "\\" {
+			continue
+		}
+		if !strings.HasPrefix(field, "--") {
+			break
+		}
+		name, value, _ := strings.Cut(field, "=")
+		switch {
+		case directive == DirectiveCopy && name == FlagFrom:
+			refs = append(refs, value)
+		case directive == DirectiveRun && name == FlagMount:
+			for _, option := range strings.Split(value, ",") {
+				if key, ref, ok := strings.Cut(option, "="); ok && key == "from" {
+					refs = append(refs, ref)
+				}
+			}
+		}
+	}
+	return directive, refs
+}
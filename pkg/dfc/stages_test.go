@@ -0,0 +1,224 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStages(t *testing.T) {
+	raw := `ARG GO_VERSION=1.24
+FROM golang:${GO_VERSION} AS base
+RUN go mod download
+
+FROM base AS builder
+RUN --mount=type=cache,target=/root/.cache \
+    --mount=type=bind,from=tools,source=/bin/lint,target=/usr/bin/lint \
+    go build -o /app
+
+FROM base AS test
+RUN go test ./...
+
+FROM gcr.io/distroless/static
+COPY --from=1 /app /app
+COPY --from=busybox:1.36 /bin/sh /bin/sh
+COPY --from=Builder /etc/app.conf /etc/`
+
+	parsed, err := ParseDockerfile(context.Background(), []byte(raw))
+	if err != nil {
+		t.Fatalf("ParseDockerfile(): %v", err)
+	}
+	graph := parsed.Stages()
+
+	expected := &StageGraph{
+		Stages: []*Stage{
+			{Number: 1, Alias: "base", Base: "golang:${GO_VERSION}", HasRun: true},
+			{Number: 2, Alias: "builder", Base: "base", Parent: 1, External: []string{"tools"}, HasRun: true},
+			{Number: 3, Alias: "test", Base: "base", Parent: 1, HasRun: true},
+			{Number: 4, Base: "gcr.io/distroless/static", CopyFrom: []int{2}, External: []string{"busybox:1.36"}},
+		},
+		Final: 4,
+	}
+	if diff := cmp.Diff(expected, graph); diff != "" {
+		t.Errorf("Stages() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := graph.Stage(4).Name(); got != "stage3" {
+		t.Errorf("Name() = %q, want %q", got, "stage3")
+	}
+	if got := graph.Lookup("BUILDER"); got == nil || got.Number != 2 {
+		t.Errorf("Lookup(BUILDER) = %v, want stage 2", got)
+	}
+	if got := graph.Lookup("0"); got == nil || got.Number != 1 {
+		t.Errorf("Lookup(0) = %v, want stage 1", got)
+	}
+	if got := graph.Lookup("alpine"); got != nil {
+		t.Errorf("Lookup(alpine) = %v, want nil", got)
+	}
+	if got := graph.Root(3); got != 1 {
+		t.Errorf("Root(3) = %d, want 1", got)
+	}
+	if diff := cmp.Diff(map[int]bool{1: true, 2: true, 4: true}, graph.Reachable(4)); diff != "" {
+		t.Errorf("Reachable(4) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{2, 3}, graph.Descendants(1)); diff != "" {
+		t.Errorf("Descendants(1) mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDevSuffixThroughParentStages(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name: "RUN in child stage",
+			raw: `FROM python:3.12 AS base
+COPY . /app
+
+FROM base AS test
+RUN apt-get update && apt-get install -y git`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev AS base
+USER root
+COPY . /app
+
+FROM base AS test
+RUN apk add --no-cache git
+`,
+		},
+		{
+			name: "RUN in grandchild stage",
+			raw: `FROM node:20 AS base
+
+FROM base AS deps
+COPY package.json .
+
+FROM deps AS build
+RUN npm ci
+
+FROM node:20
+COPY --from=build /app /app`,
+			expected: `FROM cgr.dev/ORG/node:20-dev AS base
+
+FROM base AS deps
+COPY package.json .
+
+FROM deps AS build
+RUN npm ci
+
+FROM cgr.dev/ORG/node:20
+COPY --from=build /app /app`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConvertTarget(t *testing.T) {
+	raw := `FROM golang:1.24 AS base
+RUN apt-get update && apt-get install -y make
+
+FROM base AS lint
+RUN apt-get install -y shellcheck
+
+FROM base AS build
+RUN make
+
+FROM debian:12 AS debug
+RUN apt-get update && apt-get install -y gdb
+
+FROM gcr.io/distroless/static
+COPY --from=build /app /app`
+
+	testCases := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{
+			name:   "final stage",
+			target: "4",
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS base
+USER root
+RUN apk add --no-cache make
+
+FROM base AS lint
+RUN apt-get install -y shellcheck
+
+FROM base AS build
+RUN make
+
+FROM debian:12 AS debug
+RUN apt-get update && apt-get install -y gdb
+
+FROM cgr.dev/ORG/static:latest
+COPY --from=build /app /app`,
+		},
+		{
+			name:   "intermediate stage",
+			target: "lint",
+			expected: `FROM cgr.dev/ORG/go:1.24-dev AS base
+USER root
+RUN apk add --no-cache make
+
+FROM base AS lint
+RUN apk add --no-cache shellcheck
+
+FROM base AS build
+RUN make
+
+FROM debian:12 AS debug
+RUN apt-get update && apt-get install -y gdb
+
+FROM gcr.io/distroless/static
+COPY --from=build /app /app`,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := ParseDockerfile(ctx, []byte(raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{Target: tc.target})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	if _, err := parsed.Convert(ctx, Options{Target: "release"}); err == nil {
+		t.Errorf("Expected error for unknown target")
+	}
+}
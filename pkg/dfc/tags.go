@@ -0,0 +1,119 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// AllImagesTagRules is the key of the tag rules that apply to every image
+const AllImagesTagRules = "*"
+
+// TagRule rewrites the tag of an image. Match is a regular expression that must match the whole
+// original tag, and Replace is the converted tag, which can refer to the captured groups of Match
+// ($1, ${name}, or ${0} for the whole tag).
+type TagRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// TagStrategy controls how much of the version in the original tag is kept
+type TagStrategy string
+
+// Tag strategies
+const (
+	TagStrategyLatest   TagStrategy = "latest"   // Always use latest (or latest-dev)
+	TagStrategyMajor    TagStrategy = "major"    // Keep the major version, e.g. 3.11.7 becomes 3
+	TagStrategyMinor    TagStrategy = "minor"    // Keep major.minor, e.g. 3.11.7 becomes 3.11 (the default)
+	TagStrategyPreserve TagStrategy = "preserve" // Keep the whole version, e.g. 3.11.7 stays 3.11.7
+)
+
+// validate checks that the tag strategy is known
+func (s TagStrategy) validate() error {
+	switch s {
+	case "", TagStrategyLatest, TagStrategyMajor, TagStrategyMinor, TagStrategyPreserve:
+		return nil
+	}
+	return fmt.Errorf("invalid tag strategy %q, must be one of %q, %q, %q or %q", s, TagStrategyLatest, TagStrategyMajor, TagStrategyMinor, TagStrategyPreserve)
+}
+
+// versionPatterns match the tags that are versions, capturing the part of the version kept by each
+// tag strategy, without the v prefix and anything after the first hyphen
+var versionPatterns = map[TagStrategy][]string{
+	TagStrategyMajor:    {`v?(\d+)(\.\d+[^-]*)?(-.*)?`},
+	TagStrategyMinor:    {`v?(\d+\.\d+)(\.[^-]*)?(-.*)?`, `v?(\d+)(-.*)?`},
+	TagStrategyPreserve: {`v?(\d+\.\d+[^-]*|\d+)(-.*)?`},
+}
+
+// tagPrefixes are prepended to the versions of the images that have them in their tags
+var tagPrefixes = map[string]string{
+	"jdk": "openjdk-",
+	"jre": "openjdk-",
+}
+
+// defaultTagRules returns the built-in tag rules of an image (or of all images), which are tried
+// after the tag rules from the mappings. They keep dynamic tags, keep the part of versions selected
+// by the tag strategy, and use latest for anything else. Some images also get a prefix, like the
+// openjdk- prefix of the Java images.
+func defaultTagRules(image string, strategy TagStrategy) []TagRule {
+	if strategy == "" {
+		strategy = TagStrategyMinor
+	}
+
+	prefix, ok := tagPrefixes[image]
+	if !ok && image != AllImagesTagRules {
+		return nil
+	}
+
+	rules := []TagRule{{Match: `.*\$.*`, Replace: prefix + "${0}"}}
+	for _, pattern := range versionPatterns[strategy] {
+		rules = append(rules, TagRule{Match: pattern, Replace: prefix + "$1"})
+	}
+	if image == AllImagesTagRules {
+		rules = append(rules, TagRule{Match: `.*`, Replace: "latest"})
+	}
+	return rules
+}
+
+// convertTag converts the tag of an image with the first matching rule: the rules for the
+// image from the mappings, the default rules for the image, the rules for all images from
+// the mappings, and finally the default rules for all images. With the latest strategy,
+// every tag is converted to latest.
+func convertTag(image, tag string, tags map[string][]TagRule, strategy TagStrategy) string {
+	if strategy == TagStrategyLatest {
+		return "latest"
+	}
+	for _, rules := range [][]TagRule{tags[image], defaultTagRules(image, strategy), tags[AllImagesTagRules], defaultTagRules(AllImagesTagRules, strategy)} {
+		for _, rule := range rules {
+			re, err := compileTagRule(rule)
+			if err != nil {
+				continue
+			}
+			if re.MatchString(tag) {
+				return re.ReplaceAllString(tag, rule.Replace)
+			}
+		}
+	}
+	return tag
+}
+
+// compileTagRule compiles the regular expression of a tag rule, anchored to match the whole tag
+func compileTagRule(rule TagRule) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + rule.Match + ")$")
+}
+
+// validateTagRules checks that the regular expressions of the tag rules are valid
+func validateTagRules(tags map[string][]TagRule) error {
+	for image, rules := range tags {
+		for _, rule := range rules {
+			if _, err := compileTagRule(rule); err != nil {
+				return fmt.Errorf("invalid tag rule %q for %s: %w", rule.Match, image, err)
+			}
+		}
+	}
+	return nil
+}
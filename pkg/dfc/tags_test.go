@@ -0,0 +1,156 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvertTag(t *testing.T) {
+	tags := map[string][]TagRule{
+		"postgres": {{Match: `v?(\d+(\.\d+)*)(-.*)?`, Replace: "$1"}},
+		"node":     {{Match: `lts(-.*)?`, Replace: "22"}},
+		"jdk":      {{Match: `(\d+)-jdk-(?P<os>.+)`, Replace: "openjdk-$1-${os}"}},
+		"*":        {{Match: `(\d+)\.x`, Replace: "$1"}},
+	}
+
+	testCases := []struct {
+		image    string
+		tag      string
+		expected string
+	}{
+		// Default rules
+		{image: "node", tag: "18", expected: "18"},
+		{image: "node", tag: "18-bullseye", expected: "18"},
+		{image: "node", tag: "v18.17.1-alpine", expected: "18.17"},
+		{image: "python", tag: "3.11-slim-bookworm", expected: "3.11"},
+		{image: "python", tag: "3.11.4.1", expected: "3.11"},
+		{image: "python", tag: "slim", expected: "latest"},
+		{image: "python", tag: "3.x.1", expected: "latest"},
+		{image: "python", tag: "latest", expected: "latest"},
+		{image: "python", tag: "${PYTHON_VERSION}", expected: "${PYTHON_VERSION}"},
+		{image: "jre", tag: "17.0.2_8-jre", expected: "openjdk-17.0"},
+		{image: "jre", tag: "${JAVA_VERSION}", expected: "openjdk-${JAVA_VERSION}"},
+		{image: "jre", tag: "latest", expected: "latest"},
+
+		// Rules from the mappings
+		{image: "postgres", tag: "16.2.1-bookworm", expected: "16.2.1"},
+		{image: "node", tag: "lts-slim", expected: "22"},
+		{image: "node", tag: "20.x", expected: "20"},
+		{image: "jdk", tag: "21-jdk-jammy", expected: "openjdk-21-jammy"},
+		{image: "jdk", tag: "21", expected: "openjdk-21"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.image+":"+tc.tag, func(t *testing.T) {
+			if got := convertTag(tc.image, tc.tag, tags, ""); got != tc.expected {
+				t.Errorf("convertTag(%q, %q) = %q, want %q", tc.image, tc.tag, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTagStrategy(t *testing.T) {
+	testCases := []struct {
+		image          string
+		tag            string
+		needsDevSuffix bool
+		expected       map[TagStrategy]string
+	}{
+		{
+			image:    "python",
+			tag:      "3.11.7-slim-bookworm",
+			expected: map[TagStrategy]string{"": "3.11", TagStrategyLatest: "latest", TagStrategyMajor: "3", TagStrategyMinor: "3.11", TagStrategyPreserve: "3.11.7"},
+		},
+		{
+			image:          "python",
+			tag:            "v3.11.7",
+			needsDevSuffix: true,
+			expected:       map[TagStrategy]string{"": "3.11-dev", TagStrategyLatest: "latest-dev", TagStrategyMajor: "3-dev", TagStrategyMinor: "3.11-dev", TagStrategyPreserve: "3.11.7-dev"},
+		},
+		{
+			image:    "node",
+			tag:      "18",
+			expected: map[TagStrategy]string{"": "18", TagStrategyLatest: "latest", TagStrategyMajor: "18", TagStrategyMinor: "18", TagStrategyPreserve: "18"},
+		},
+		{
+			image:          "node",
+			tag:            "${NODE_VERSION}",
+			needsDevSuffix: true,
+			expected:       map[TagStrategy]string{"": "${NODE_VERSION}-dev", TagStrategyLatest: "latest-dev", TagStrategyMajor: "${NODE_VERSION}-dev", TagStrategyMinor: "${NODE_VERSION}-dev", TagStrategyPreserve: "${NODE_VERSION}-dev"},
+		},
+		{
+			image:    "jdk",
+			tag:      "17.0.2_8-jdk",
+			expected: map[TagStrategy]string{"": "openjdk-17.0", TagStrategyLatest: "latest", TagStrategyMajor: "openjdk-17", TagStrategyMinor: "openjdk-17.0", TagStrategyPreserve: "openjdk-17.0.2_8"},
+		},
+		{
+			image:          DefaultChainguardBase,
+			tag:            "12.4",
+			needsDevSuffix: true,
+			expected:       map[TagStrategy]string{"": "latest", TagStrategyLatest: "latest", TagStrategyMajor: "latest", TagStrategyMinor: "latest", TagStrategyPreserve: "latest"},
+		},
+	}
+
+	for _, tc := range testCases {
+		for strategy, expected := range tc.expected {
+			t.Run(tc.image+":"+tc.tag+"/"+string(strategy), func(t *testing.T) {
+				if got := calculateConvertedTag(tc.image, tc.tag, tc.needsDevSuffix, nil, strategy); got != expected {
+					t.Errorf("calculateConvertedTag(%q, %q, %t) = %q, want %q", tc.image, tc.tag, tc.needsDevSuffix, got, expected)
+				}
+			})
+		}
+	}
+
+	parsed, err := ParseDockerfile(context.Background(), []byte("FROM python:3.11.7"))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	if _, err := parsed.Convert(context.Background(), Options{TagStrategy: "exact"}); err == nil {
+		t.Errorf("Expected error for unknown tag strategy")
+	}
+}
+
+func TestTagRulesConversion(t *testing.T) {
+	raw := `FROM postgres:16.2.1-bookworm AS db
+FROM node:lts-slim
+RUN npm ci`
+	expected := `FROM cgr.dev/ORG/postgres:16.2.1 AS db
+FROM cgr.dev/ORG/node:22-dev
+RUN npm ci`
+
+	ctx := context.Background()
+	parsed, err := ParseDockerfile(ctx, []byte(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	converted, err := parsed.Convert(ctx, Options{
+		ExtraMappings: MappingsConfig{
+			Tags: map[string][]TagRule{
+				"postgres": {{Match: `v?(\d+(\.\d+)*)(-.*)?`, Replace: "$1"}},
+				"node":     {{Match: `lts(-.*)?`, Replace: "22"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert Dockerfile: %v", err)
+	}
+	if diff := cmp.Diff(expected, converted.String()); diff != "" {
+		t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+	}
+
+	_, err = parsed.Convert(ctx, Options{
+		ExtraMappings: MappingsConfig{
+			Tags: map[string][]TagRule{"node": {{Match: `lts(`, Replace: "22"}}},
+		},
+	})
+	if err == nil {
+		t.Errorf("Expected error for invalid tag rule")
+	}
+}
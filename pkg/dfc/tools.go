@@ -0,0 +1,261 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Ecosystems of the language package managers installing tools
+const (
+	EcosystemPip   = "pip"
+	EcosystemNpm   = "npm"
+	EcosystemGem   = "gem"
+	EcosystemCargo = "cargo"
+	EcosystemGo    = "go"
+)
+
+// ToolMap maps the tools installed with the package manager of an ecosystem to the apk packages
+// providing them. An empty package keeps the tool installed with the package manager.
+type ToolMap map[string]string
+
+// defaultTools are the tools packaged in Wolfi, used for the tools without a mapping
+var defaultTools = map[string]ToolMap{
+	EcosystemPip: {
+		"awscli":     "aws-cli",
+		"supervisor": "supervisor",
+		"ansible":    "ansible",
+		"poetry":     "poetry",
+		"uv":         "uv",
+		"pre-commit": "pre-commit",
+	},
+	EcosystemNpm: {
+		"yarn": "yarn",
+		"pnpm": "pnpm",
+	},
+	EcosystemCargo: {
+		"ripgrep": "ripgrep",
+		"fd-find": "fd",
+		"bat":     "bat",
+	},
+	EcosystemGo: {
+		"github.com/go-delve/delve/cmd/dlv": "delve",
+		"golang.org/x/tools/gopls":          "gopls",
+	},
+}
+
+// pipCommand matches the pip commands, like pip3 or pip3.12
+var pipCommand = regexp.MustCompile(`^pip(?:\d+(?:\.\d+)?)?$`)
+
+// toolValueFlags are the options of the install commands of each ecosystem that take a value
+var toolValueFlags = map[string][]string{
+	EcosystemPip:   {"-r", "--requirement", "-c", "--constraint", "-e", "--editable", "-i", "--index-url", "--extra-index-url", "-f", "--find-links", "-t", "--target", "--prefix", "--root", "--trusted-host", "--platform", "--python-version", "--src", "--cache-dir", "--log", "--progress-bar", "--upgrade-strategy"},
+	EcosystemNpm:   {"--prefix", "--registry", "--cache", "--loglevel", "--omit", "--include"},
+	EcosystemGem:   {"-v", "--version", "-i", "--install-dir", "-n", "--bindir", "-s", "--source", "--platform"},
+	EcosystemCargo: {"--version", "--vers", "--git", "--branch", "--tag", "--rev", "--path", "--root", "--index", "--registry", "--features", "-F", "-j", "--jobs", "--target", "--profile", "--bin", "--example"},
+	EcosystemGo:    {"-ldflags", "-gcflags", "-tags", "-mod", "-modfile", "-o", "-p"},
+}
+
+// toolVersionFlags are the options giving the version of the installed packages
+var toolVersionFlags = []string{"-v", "--version", "--vers"}
+
+// toolSourceFlags are the options installing packages from elsewhere than the registry of the
+// ecosystem, which are left to it
+var toolSourceFlags = []string{"-r", "--requirement", "-e", "--editable", "--git", "--path"}
+
+// toolArg is a package installed by a tool install command
+type toolArg struct {
+	Index   int // Index in the arguments of the command
+	Name    string
+	Version string // Exact version, if any
+}
+
+// toolInstall is a command installing packages with the package manager of an ecosystem
+type toolInstall struct {
+	Ecosystem string
+	Packages  []toolArg
+	Version   string // Version given with an option, for all the packages
+	Other     bool   // Whether it also installs packages from elsewhere (requirements files, paths...)
+}
+
+// parseToolInstall parses the install commands of pip, npm (global installs), gem, cargo and go
+func parseToolInstall(part *ShellPart) (toolInstall, bool) {
+	var install toolInstall
+	args := part.Args
+	offset := 0
+	switch {
+	case pipCommand.MatchString(part.Command):
+		install.Ecosystem = EcosystemPip
+	case (part.Command == "python" || part.Command == "python3") && len(args) > 1 && args[0] == "-m" && pipCommand.MatchString(args[1]):
+		install.Ecosystem = EcosystemPip
+		offset = 2
+	case part.Command == EcosystemNpm && (slices.Contains(args, "-g") || slices.Contains(args, "--global")):
+		install.Ecosystem = EcosystemNpm
+	case part.Command == EcosystemGem || part.Command == EcosystemCargo || part.Command == EcosystemGo:
+		install.Ecosystem = part.Command
+	default:
+		return toolInstall{}, false
+	}
+
+	// The subcommand comes first, after the options of pip
+	i := offset
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		i++
+	}
+	if i == len(args) || (args[i] != "install" && !(install.Ecosystem == EcosystemNpm && (args[i] == "i" || args[i] == "add"))) {
+		return toolInstall{}, false
+	}
+
+	valueFlags := toolValueFlags[install.Ecosystem]
+	for i++; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		if strings.HasPrefix(arg, "-") {
+			if slices.Contains(toolSourceFlags, name) {
+				install.Other = true
+			}
+			if slices.Contains(valueFlags, name) && !hasValue && i+1 < len(args) {
+				value = args[i+1]
+				i++
+			}
+			if slices.Contains(toolVersionFlags, name) && slices.Contains(valueFlags, name) {
+				install.Version = strings.Trim(value, `"'`)
+			}
+			continue
+		}
+		if pkg, ok := parseToolArg(install.Ecosystem, strings.Trim(arg, `"'`)); ok {
+			pkg.Index = i
+			install.Packages = append(install.Packages, pkg)
+		} else {
+			install.Other = true
+		}
+	}
+	return install, true
+}
+
+// pipRequirement matches a pip requirement, with its extras and exact version
+var pipRequirement = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(?:\[[^\]]*\])?(?:==([^,;\s]+)|[<>=!~].*)?$`)
+
+// parseToolArg parses a package argument of an install command into its name and exact version.
+// Arguments that are not packages of the registry (paths, URLs...) are reported false.
+func parseToolArg(ecosystem, arg string) (toolArg, bool) {
+	var pkg toolArg
+	switch ecosystem {
+	case EcosystemPip:
+		match := pipRequirement.FindStringSubmatch(arg)
+		if match == nil {
+			return toolArg{}, false
+		}
+		pkg.Name, pkg.Version = strings.ToLower(match[1]), match[2]
+	case EcosystemNpm:
+		// Scoped packages start with @: @scope/name@version
+		if i := strings.LastIndex(arg, "@"); i > 0 {
+			pkg.Name, pkg.Version = arg[:i], arg[i+1:]
+		} else {
+			pkg.Name = arg
+		}
+		if strings.HasPrefix(pkg.Name, ".") || strings.Contains(pkg.Name, ":") || (strings.Contains(pkg.Name, "/") && !strings.HasPrefix(pkg.Name, "@")) {
+			return toolArg{}, false
+		}
+	case EcosystemGem:
+		pkg.Name, pkg.Version, _ = strings.Cut(arg, ":")
+	case EcosystemCargo:
+		pkg.Name, pkg.Version, _ = strings.Cut(arg, "@")
+	case EcosystemGo:
+		pkg.Name, pkg.Version, _ = strings.Cut(arg, "@")
+		pkg.Version = strings.TrimPrefix(pkg.Version, "v")
+		if strings.HasPrefix(pkg.Name, ".") {
+			return toolArg{}, false
+		}
+	}
+	// Only exact versions are kept
+	if !tagVersion.MatchString(pkg.Version) || strings.ContainsAny(pkg.Version, "^~<>*x") {
+		pkg.Version = ""
+	}
+	return pkg, pkg.Name != ""
+}
+
+// resolveTool returns the package providing a tool, from the mappings or the built-in defaults
+func resolveTool(ecosystem, name string, tools map[string]ToolMap) (string, bool) {
+	if pkg, ok := tools[ecosystem][name]; ok {
+		return pkg, pkg != ""
+	}
+	pkg, ok := defaultTools[ecosystem][name]
+	return pkg, ok
+}
+
+// convertToolInstalls replaces the tools installed with pip, npm -g, gem, cargo or go install by
+// their apk packages. Install commands only installing such tools are removed, the others keep
+// installing the rest of their packages. It returns the packages installed and notes about the
+// replaced tools.
+func convertToolInstalls(shell *ShellCommand, tools map[string]ToolMap) (bool, []string, *ShellCommand, []string) {
+	if shell == nil || len(shell.Parts) == 0 {
+		return false, nil, shell, nil
+	}
+
+	var packages, notes []string
+	newParts := make([]*ShellPart, 0, len(shell.Parts))
+	first := -1
+	for _, part := range shell.Parts {
+		install, ok := parseToolInstall(part)
+		if !ok || len(splitPipeline(part)) > 1 {
+			newParts = append(newParts, cloneShellPart(part))
+			continue
+		}
+
+		var replaced, apkPackages []string
+		var removed []int
+		for _, pkg := range install.Packages {
+			apkPackage, ok := resolveTool(install.Ecosystem, pkg.Name, tools)
+			if !ok {
+				continue
+			}
+			version := pkg.Version
+			if version == "" {
+				version = install.Version
+			}
+			replaced = append(replaced, pkg.Name)
+			apkPackages = append(apkPackages, createApkPackageSpec(apkPackage, PackageSpec{Version: version}))
+			removed = append(removed, pkg.Index)
+		}
+		// A version option applies to all the packages, which cannot be split
+		if len(replaced) == 0 || (install.Version != "" && len(replaced) < len(install.Packages)) {
+			newParts = append(newParts, cloneShellPart(part))
+			continue
+		}
+
+		if first == -1 {
+			first = len(newParts)
+		}
+		for _, pkg := range apkPackages {
+			if !slices.Contains(packages, pkg) {
+				packages = append(packages, pkg)
+			}
+		}
+		notes = append(notes, fmt.Sprintf("installed %s instead of %s from %s", strings.Join(apkPackages, ", "), strings.Join(replaced, ", "), install.Ecosystem))
+
+		// Keep installing the other packages
+		if len(replaced) < len(install.Packages) || install.Other {
+			kept := cloneShellPart(part)
+			kept.Args = nil
+			for i, arg := range part.Args {
+				if !slices.Contains(removed, i) {
+					kept.Args = append(kept.Args, arg)
+				}
+			}
+			newParts = append(newParts, kept)
+		}
+	}
+	if len(packages) == 0 {
+		return false, nil, shell, nil
+	}
+
+	// The packages are installed where the first replaced install was
+	return true, packages, &ShellCommand{Parts: replaceWithApkPackages(newParts, packages, first)}, notes
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvertToolInstalls(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		disabled      bool
+		mappings      MappingsConfig
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "disabled by default",
+			raw: `FROM python:3.12
+RUN pip install --no-cache-dir awscli supervisor`,
+			disabled: true,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+RUN pip install --no-cache-dir awscli supervisor`,
+		},
+		{
+			name: "only tools",
+			raw: `FROM python:3.12
+RUN pip install --no-cache-dir awscli supervisor`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache aws-cli supervisor
+`,
+			expectedNotes: []string{"installed aws-cli, supervisor instead of awscli, supervisor from pip"},
+		},
+		{
+			name: "tools and app dependencies",
+			raw: `FROM node:20
+RUN npm install -g yarn@1.22.19 typescript && npm ci`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+USER root
+RUN apk add --no-cache yarn=~1.22.19 && \
+    npm install -g typescript && \
+    npm ci
+`,
+			expectedNotes: []string{"installed yarn=~1.22.19 instead of yarn from npm"},
+		},
+		{
+			name: "requirements files are left to pip",
+			raw: `FROM python:3.12
+RUN python3 -m pip install -r requirements.txt poetry==1.8.2`,
+			expected: `FROM cgr.dev/ORG/python:3.12-dev
+USER root
+RUN apk add --no-cache poetry=~1.8.2 && \
+    python3 -m pip install -r requirements.txt
+`,
+			expectedNotes: []string{"installed poetry=~1.8.2 instead of poetry from pip"},
+		},
+		{
+			name: "local npm installs are kept",
+			raw: `FROM node:20
+RUN npm install yarn`,
+			expected: `FROM cgr.dev/ORG/node:20-dev
+RUN npm install yarn`,
+		},
+		{
+			name: "version option and mappings",
+			raw: `FROM ruby:3.3
+RUN gem install bundler -v 2.5.6 && go install github.com/example/tool@latest`,
+			mappings: MappingsConfig{Tools: map[string]ToolMap{
+				EcosystemGem: {"bundler": "ruby3.3-bundler"},
+				EcosystemGo:  {"github.com/example/tool": "example-tool"},
+			}},
+			expected: `FROM cgr.dev/ORG/ruby:3.3-dev
+USER root
+RUN apk add --no-cache ruby3.3-bundler=~2.5.6 example-tool
+`,
+			expectedNotes: []string{
+				"installed ruby3.3-bundler=~2.5.6 instead of bundler from gem",
+				"installed example-tool instead of github.com/example/tool from go",
+			},
+		},
+		{
+			name: "default disabled by the mappings",
+			raw: `FROM rust:1.77
+RUN cargo install ripgrep`,
+			mappings: MappingsConfig{Tools: map[string]ToolMap{EcosystemCargo: {"ripgrep": ""}}},
+			expected: `FROM cgr.dev/ORG/rust:1.77-dev
+RUN cargo install ripgrep`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, Options{ExtraMappings: tc.mappings, PreferApkForTools: !tc.disabled})
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From == nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -27,6 +27,9 @@ const (
 
 	// orgName is the organization name used in XDG paths
 	orgName = "dev.chainguard.dfc"
+
+	// DefaultUserAgent is the user agent of network requests when none is given
+	DefaultUserAgent = "dfc/dev"
 )
 
 // UpdateOptions configures the update behavior
@@ -231,7 +234,7 @@ func Update(ctx context.Context, opts UpdateOptions) error {
 	// Set the User-Agent header
 	userAgent := opts.UserAgent
 	if userAgent == "" {
-		userAgent = "dfc/dev"
+		userAgent = DefaultUserAgent
 	}
 	req.Header.Set("User-Agent", userAgent)
 
@@ -0,0 +1,285 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Version managers installing runtimes
+const (
+	CommandNvm   = "nvm"
+	CommandPyenv = "pyenv"
+	CommandRbenv = "rbenv"
+	CommandSdk   = "sdk"
+)
+
+// managedRuntime is a runtime installed by a version manager, and its Wolfi packages
+type managedRuntime struct {
+	Package string // Package name, with %s for the version stream if it has one
+	Digits  int    // Number of version components of the version stream (18 for node, 3.11 for python)
+	Image   string // Chainguard image of the runtime
+}
+
+// versionManagers are the runtimes installed by each version manager, by candidate for sdkman
+// (the other version managers install a single runtime)
+var versionManagers = map[string]map[string]managedRuntime{
+	CommandNvm:   {"": {Package: "nodejs-%s", Digits: 1, Image: "node"}},
+	CommandPyenv: {"": {Package: "python-%s", Digits: 2, Image: "python"}},
+	CommandRbenv: {"": {Package: "ruby-%s", Digits: 2, Image: "ruby"}},
+	CommandSdk: {
+		"java":   {Package: "openjdk-%s", Digits: 1, Image: "jdk"},
+		"maven":  {Package: "maven", Image: "maven"},
+		"gradle": {Package: "gradle", Image: "gradle"},
+	},
+}
+
+// versionManagerBootstrap matches the URLs of the install scripts and repositories of version managers
+var versionManagerBootstrap = regexp.MustCompile(`nvm-sh/nvm|pyenv\.run|pyenv/pyenv|pyenv-installer|rbenv/rbenv|rbenv/ruby-build|rbenv-installer|get\.sdkman\.io`)
+
+// versionManagerSetup matches the scripts and commands setting up version managers in a shell
+var versionManagerSetup = regexp.MustCompile(`nvm\.sh|sdkman-init\.sh|pyenv init|rbenv init|pyenv virtualenv-init`)
+
+// versionManagerVersion matches the version of a runtime, like v18.19.0 or 17.0.9-tem
+var versionManagerVersion = regexp.MustCompile(`^v?(\d+(?:\.\d+)*)`)
+
+// variableReference matches an argument that is a variable reference, like $NODE_VERSION or ${NODE_VERSION}
+var variableReference = regexp.MustCompile(`^\$\{?(\w+)\}?$`)
+
+// runtimeInstall is a runtime installed by a version manager
+type runtimeInstall struct {
+	Command string // Original command, like nvm install 18
+	Runtime managedRuntime
+	Package string // Package providing the version, empty if it is unknown
+	Version string
+}
+
+// isVersionManagerPlumbing reports whether a shell part bootstraps a version manager, sets it up
+// in the shell, or runs it for something else than installing a runtime (use, global, alias...)
+func isVersionManagerPlumbing(part *ShellPart) bool {
+	commands := splitPipeline(part)
+	text := strings.Join(append([]string{part.Command}, part.Args...), " ")
+	switch commands[0].Command {
+	case CommandNvm, CommandPyenv, CommandRbenv, CommandSdk:
+		return true
+	case CommandCurl, CommandWget:
+		// Install scripts piped into a shell
+		return len(commands) > 1 && versionManagerBootstrap.MatchString(text)
+	case "git":
+		return len(part.Args) > 0 && part.Args[0] == "clone" && versionManagerBootstrap.MatchString(text)
+	case ".", `\.`, "source", "eval":
+		return versionManagerSetup.MatchString(text)
+	case "echo":
+		// Setup appended to shell profiles
+		return slices.Contains(part.Args, ">>") && versionManagerSetup.MatchString(text)
+	}
+	return false
+}
+
+// parseRuntimeInstall parses a command installing a runtime with a version manager, resolving
+// version variables with the ARG and ENV values of the stage
+func parseRuntimeInstall(part *ShellPart, vars map[string]string) (runtimeInstall, bool) {
+	runtimes, ok := versionManagers[part.Command]
+	if !ok || len(splitPipeline(part)) > 1 {
+		return runtimeInstall{}, false
+	}
+	var args []string
+	for _, arg := range part.Args {
+		if !strings.HasPrefix(arg, "-") {
+			args = append(args, strings.Trim(arg, `"'`))
+		}
+	}
+	if len(args) == 0 || args[0] != "install" {
+		return runtimeInstall{}, false
+	}
+	args = args[1:]
+
+	candidate := ""
+	if part.Command == CommandSdk {
+		if len(args) == 0 {
+			return runtimeInstall{}, false
+		}
+		candidate, args = args[0], args[1:]
+	}
+	runtime, ok := runtimes[candidate]
+	if !ok {
+		return runtimeInstall{}, false
+	}
+
+	install := runtimeInstall{
+		Command: strings.Join(append([]string{part.Command}, part.Args...), " "),
+		Runtime: runtime,
+	}
+	if !strings.Contains(runtime.Package, "%s") {
+		install.Package = runtime.Package
+		return install, true
+	}
+	if len(args) == 0 {
+		return install, true
+	}
+	version := args[0]
+	if match := variableReference.FindStringSubmatch(version); match != nil {
+		version = vars[match[1]]
+	}
+	match := versionManagerVersion.FindStringSubmatch(version)
+	if match == nil {
+		return install, true
+	}
+	components := strings.Split(match[1], ".")
+	if len(components) > runtime.Digits {
+		components = components[:runtime.Digits]
+	}
+	install.Version = strings.Join(components, ".")
+	install.Package = fmt.Sprintf(runtime.Package, install.Version)
+	return install, true
+}
+
+// versionManagerCommands returns the runtimes installed by a shell part, and whether it only
+// installs runtimes and sets up version managers, so that it can be replaced. Scripts run with
+// bash -c or sh -c are looked into.
+func versionManagerCommands(part *ShellPart, vars map[string]string) ([]runtimeInstall, bool) {
+	parts := []*ShellPart{part}
+	if (part.Command == "bash" || part.Command == "sh") && len(part.Args) == 2 && part.Args[0] == "-c" {
+		script := ParseMultilineShell(strings.Trim(part.Args[1], `"'`))
+		if script == nil {
+			return nil, false
+		}
+		parts = script.Parts
+	}
+
+	var installs []runtimeInstall
+	for _, p := range parts {
+		if install, ok := parseRuntimeInstall(p, vars); ok {
+			installs = append(installs, install)
+		} else if !isVersionManagerPlumbing(p) {
+			return nil, false
+		}
+	}
+	return installs, true
+}
+
+// stageVariables returns the values of the ARG and ENV variables of each stage, including the
+// ARGs declared before the first FROM
+func stageVariables(lines []*DockerfileLine) map[int]map[string]string {
+	vars := make(map[int]map[string]string)
+	for _, line := range lines {
+		if vars[line.Stage] == nil {
+			vars[line.Stage] = make(map[string]string)
+			for name, value := range vars[0] {
+				vars[line.Stage][name] = value
+			}
+		}
+		directive, args := cutInstruction(line.Raw)
+		switch directive {
+		case "ARG":
+			if name, value, ok := strings.Cut(args, "="); ok {
+				vars[line.Stage][name] = strings.Trim(value, `"'`)
+			}
+		case "ENV":
+			if name, value, ok := strings.Cut(args, " "); ok && !strings.Contains(name, "=") {
+				vars[line.Stage][name] = strings.Trim(strings.TrimSpace(value), `"'`)
+				continue
+			}
+			for _, field := range strings.Fields(args) {
+				if name, value, ok := strings.Cut(field, "="); ok {
+					vars[line.Stage][name] = strings.Trim(value, `"'`)
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// replaceVersionManagers replaces the runtimes installed with nvm, pyenv, rbenv or sdkman by their
+// versioned Wolfi packages, and removes the bootstrap and setup of the version managers. Stages
+// installing a runtime whose version is unknown are left as is, with a note. A note suggests
+// building the stage on the image of the runtime instead. RUN lines left without commands are
+// dropped.
+func replaceVersionManagers(lines []*DockerfileLine, graph *StageGraph, stageImages map[int]convertedImage, opts Options) []*DockerfileLine {
+	vars := stageVariables(lines)
+
+	// Find the runtimes installed by each stage
+	installs := make(map[int][]runtimeInstall)
+	unknown := make(map[int]bool)
+	for _, line := range lines {
+		if !graph.Builds(line.Stage) {
+			continue
+		}
+		for _, part := range runShellParts(line) {
+			partInstalls, _ := versionManagerCommands(part, vars[line.Stage])
+			for _, install := range partInstalls {
+				installs[line.Stage] = append(installs[line.Stage], install)
+				if install.Package == "" {
+					unknown[line.Stage] = true
+					line.Notes = append(line.Notes, fmt.Sprintf("could not replace %s with a package: the version is unknown", install.Command))
+				}
+			}
+		}
+	}
+
+	suggested := make(map[int]bool)
+	empty := make(map[*DockerfileLine]bool)
+	for _, line := range lines {
+		if line.From != nil || len(installs[line.Stage]) == 0 || unknown[line.Stage] {
+			continue
+		}
+		parts := runShellParts(line)
+		if len(parts) == 0 {
+			continue
+		}
+
+		var packages []string
+		changed := false
+		first := -1
+		newParts := make([]*ShellPart, 0, len(parts))
+		for _, part := range parts {
+			partInstalls, ok := versionManagerCommands(part, vars[line.Stage])
+			if !ok {
+				newParts = append(newParts, cloneShellPart(part))
+				continue
+			}
+			changed = true
+			for _, install := range partInstalls {
+				if first == -1 {
+					first = len(newParts)
+				}
+				packages = append(packages, install.Package)
+				line.Notes = append(line.Notes, fmt.Sprintf("installed %s instead of %s", install.Package, install.Command))
+				if !suggested[line.Stage] && stageImages[line.Stage].Mapping.Image != install.Runtime.Image {
+					// The tag is converted like the tags of FROM lines
+					tag := calculateConvertedTag(install.Runtime.Image, install.Version, true, opts.ExtraMappings.Tags, opts.TagStrategy)
+					tag, _ = opts.TagCatalog.checkTag(install.Runtime.Image, tag)
+					line.Notes = append(line.Notes, fmt.Sprintf("consider building this stage on %s instead of installing %s", buildImageReference(install.Runtime.Image, tag, opts), install.Package))
+					suggested[line.Stage] = true
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		if len(packages) > 0 {
+			newParts = replaceWithApkPackages(newParts, packages, first)
+		} else if len(newParts) > 0 {
+			newParts[len(newParts)-1].Delimiter = ""
+		}
+		if len(packages) == 0 {
+			line.Notes = append(line.Notes, "removed the setup of version managers: runtimes are installed from packages")
+		}
+		if len(newParts) == 0 {
+			// Nothing left to run
+			empty[line] = true
+			continue
+		}
+		shell := &ShellCommand{Parts: newParts}
+		line.Run.Shell.After = shell
+		line.Converted = runInstruction(line.Raw, shell)
+	}
+	return dropLines(lines, empty)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2025 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dfc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReplaceVersionManagers(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		opts          Options
+		expected      string
+		expectedNotes []string
+	}{
+		{
+			name: "nvm with its bootstrap",
+			raw: `FROM debian:12
+ENV NVM_DIR=/root/.nvm NODE_VERSION=18.19.0
+RUN curl -o- https://raw.githubusercontent.com/nvm-sh/nvm/v0.39.7/install.sh | bash
+RUN . "$NVM_DIR/nvm.sh" && nvm install ${NODE_VERSION} && nvm use ${NODE_VERSION} && npm ci`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+ENV NVM_DIR=/root/.nvm NODE_VERSION=18.19.0
+RUN apk add --no-cache nodejs-18 && \
+    npm ci
+`,
+			expectedNotes: []string{
+				"removed the setup of version managers: runtimes are installed from packages",
+				"installed nodejs-18 instead of nvm install ${NODE_VERSION}",
+				"consider building this stage on cgr.dev/ORG/node:18-dev instead of installing nodejs-18",
+			},
+		},
+		{
+			name: "pyenv added to the converted apk add",
+			raw: `FROM ubuntu:22.04
+RUN apt-get update && apt-get install -y git build-essential && \
+    git clone https://github.com/pyenv/pyenv.git /root/.pyenv && \
+    eval "$(pyenv init -)" && \
+    pyenv install 3.11.4 && \
+    pyenv global 3.11.4`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache build-base git python-3.11
+`,
+			expectedNotes: []string{
+				"installed python-3.11 instead of pyenv install 3.11.4",
+				"consider building this stage on cgr.dev/ORG/python:3.11-dev instead of installing python-3.11",
+			},
+		},
+		{
+			name: "sdkman run with bash -c",
+			raw: `FROM debian:12
+RUN curl -s "https://get.sdkman.io" | bash && \
+    bash -c "source /root/.sdkman/bin/sdkman-init.sh && sdk install java 17.0.9-tem && sdk install maven"`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache openjdk-17 maven
+`,
+			expectedNotes: []string{
+				"installed openjdk-17 instead of sdk install java 17.0.9-tem",
+				"consider building this stage on cgr.dev/ORG/jdk:openjdk-17-dev instead of installing openjdk-17",
+				"installed maven instead of sdk install maven",
+			},
+		},
+		{
+			name: "unknown version",
+			raw: `FROM debian:12
+RUN curl -fsSL https://github.com/rbenv/rbenv-installer/raw/HEAD/bin/rbenv-installer | bash
+RUN rbenv install $RUBY_VERSION`,
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN echo "TODO: replace the installer script https://github.com/rbenv/rbenv-installer/raw/HEAD/bin/rbenv-installer with apk packages" >&2 && \
+    exit 1
+RUN rbenv install $RUBY_VERSION`,
+			expectedNotes: []string{
+				"replaced the installer script https://github.com/rbenv/rbenv-installer/raw/HEAD/bin/rbenv-installer with a failing TODO: no package is known to replace it",
+				"could not replace rbenv install $RUBY_VERSION with a package: the version is unknown",
+			},
+		},
+		{
+			name: "suggested tag converted with the tag strategy",
+			raw: `FROM debian:12
+RUN pyenv install 3.11.4`,
+			opts: Options{TagStrategy: TagStrategyMajor},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache python-3.11
+`,
+			expectedNotes: []string{
+				"installed python-3.11 instead of pyenv install 3.11.4",
+				"consider building this stage on cgr.dev/ORG/python:3-dev instead of installing python-3.11",
+			},
+		},
+		{
+			name: "suggested tag converted with the tag rules of the mappings",
+			raw: `FROM debian:12
+RUN nvm install 20.11.0`,
+			opts: Options{ExtraMappings: MappingsConfig{Tags: map[string][]TagRule{"node": {{Match: `(\d+)(?:\..*)?`, Replace: "$1-slim"}}}}},
+			expected: `FROM cgr.dev/ORG/chainguard-base:latest
+USER root
+RUN apk add --no-cache nodejs-20
+`,
+			expectedNotes: []string{
+				"installed nodejs-20 instead of nvm install 20.11.0",
+				"consider building this stage on cgr.dev/ORG/node:20-slim-dev instead of installing nodejs-20",
+			},
+		},
+		{
+			name: "runtime image already used",
+			raw: `FROM node:18
+RUN nvm install 18 && npm ci`,
+			expected: `FROM cgr.dev/ORG/node:18-dev
+USER root
+RUN apk add --no-cache nodejs-18 && \
+    npm ci
+`,
+			expectedNotes: []string{"installed nodejs-18 instead of nvm install 18"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			parsed, err := ParseDockerfile(ctx, []byte(tc.raw))
+			if err != nil {
+				t.Fatalf("Failed to parse Dockerfile: %v", err)
+			}
+			converted, err := parsed.Convert(ctx, tc.opts)
+			if err != nil {
+				t.Fatalf("Failed to convert Dockerfile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, converted.String()); diff != "" {
+				t.Errorf("conversion not as expected (-want, +got):\n%s", diff)
+			}
+
+			var notes []string
+			for _, line := range converted.Lines {
+				if line.From == nil {
+					notes = append(notes, line.Notes...)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedNotes, notes); diff != "" {
+				t.Errorf("notes not as expected (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}